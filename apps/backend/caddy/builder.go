@@ -3,9 +3,12 @@ package caddy
 import (
 	"caddyadmin/database"
 	"caddyadmin/models"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"regexp"
 	"strings"
+	"time"
 )
 
 // ConfigBuilder helps construct Caddy JSON configurations
@@ -23,6 +26,22 @@ type CaddyConfig struct {
 	Admin   *AdminConfig           `json:"admin,omitempty"`
 	Logging *LoggingConfig         `json:"logging,omitempty"`
 	Apps    map[string]interface{} `json:"apps,omitempty"`
+
+	// Warnings lists the items the build skipped; they are reported, not sent to Caddy
+	Warnings []string `json:"-"`
+}
+
+// buildWarnings collects the items skipped while building so sync responses can show them
+type buildWarnings struct {
+	messages []string
+}
+
+// add records a skipped item; a nil collector discards it
+func (w *buildWarnings) add(format string, args ...interface{}) {
+	if w == nil {
+		return
+	}
+	w.messages = append(w.messages, fmt.Sprintf(format, args...))
 }
 
 // LoggingConfig represents global logging configuration
@@ -54,7 +73,19 @@ type HTTPServer struct {
 	Routes            []Route         `json:"routes,omitempty"`
 	AutoHTTPS         *AutoHTTPSConfig `json:"automatic_https,omitempty"`
 	TLSConnectionPolicies []interface{} `json:"tls_connection_policies,omitempty"`
+	Errors            *HTTPErrorConfig `json:"errors,omitempty"`
 	Logs              *ServerLogs     `json:"logs,omitempty"`
+	// Tuning, see ServerTuning
+	ReadTimeout       string            `json:"read_timeout,omitempty"`
+	ReadHeaderTimeout string            `json:"read_header_timeout,omitempty"`
+	WriteTimeout      string            `json:"write_timeout,omitempty"`
+	IdleTimeout       string            `json:"idle_timeout,omitempty"`
+	MaxHeaderBytes    int               `json:"max_header_bytes,omitempty"`
+	TrustedProxies    *TrustedProxies   `json:"trusted_proxies,omitempty"`
+	ClientIPHeaders   []string          `json:"client_ip_headers,omitempty"`
+	Protocols         []string          `json:"protocols,omitempty"`
+	ListenerWrappers  []ListenerWrapper `json:"listener_wrappers,omitempty"`
+	StrictSNIHost     *bool             `json:"strict_sni_host,omitempty"`
 }
 
 // AutoHTTPSConfig represents automatic HTTPS configuration
@@ -84,9 +115,32 @@ type Route struct {
 
 // Match represents route matching criteria
 type Match struct {
-	Host   []string `json:"host,omitempty"`
-	Path   []string `json:"path,omitempty"`
-	Method []string `json:"method,omitempty"`
+	Host       []string      `json:"host,omitempty"`
+	Path       []string      `json:"path,omitempty"`
+	Method     []string      `json:"method,omitempty"`
+	ClientIP   *IPRangeMatch `json:"client_ip,omitempty"`
+	PathRegexp *RegexpMatch  `json:"path_regexp,omitempty"`
+	File       *FileMatch    `json:"file,omitempty"`
+	Vars       map[string][]string `json:"vars,omitempty"`
+	Not        []Match       `json:"not,omitempty"`
+	// Request property matchers
+	Header       map[string][]string     `json:"header,omitempty"`
+	HeaderRegexp map[string]*RegexpMatch `json:"header_regexp,omitempty"`
+	Query        map[string][]string     `json:"query,omitempty"`
+	RemoteIP     *IPRangeMatch           `json:"remote_ip,omitempty"`
+	Protocol     string                  `json:"protocol,omitempty"`
+	Expression   string                  `json:"expression,omitempty"` // CEL
+}
+
+// RegexpMatch represents a named regexp matcher; captures are exposed as {http.regexp.<name>.<n>}
+type RegexpMatch struct {
+	Name    string `json:"name,omitempty"`
+	Pattern string `json:"pattern"`
+}
+
+// IPRangeMatch represents a client_ip/remote_ip matcher
+type IPRangeMatch struct {
+	Ranges []string `json:"ranges"`
 }
 
 // Handler represents a route handler
@@ -95,15 +149,29 @@ type Handler struct {
 	Body          string      `json:"body,omitempty"`           // for static_response
 	Root          string      `json:"root,omitempty"`           // for file_server
 	Browse        interface{} `json:"browse,omitempty"`         // for file_server
+	IndexNames    []string    `json:"index_names,omitempty"`    // for file_server
+	Hide          []string    `json:"hide,omitempty"`           // for file_server
+	PassThru      bool        `json:"pass_thru,omitempty"`      // for file_server
+	Precompressed      map[string]interface{} `json:"precompressed,omitempty"`       // for file_server
+	PrecompressedOrder []string               `json:"precompressed_order,omitempty"` // for file_server
 	Upstreams     []Upstream  `json:"upstreams,omitempty"`      // for reverse_proxy
 	Transport     interface{} `json:"transport,omitempty"`      // for reverse_proxy
 	LoadBalancing interface{} `json:"load_balancing,omitempty"` // for reverse_proxy
 	HealthChecks  interface{} `json:"health_checks,omitempty"`  // for reverse_proxy
-	Headers       *Headers    `json:"headers,omitempty"`        // for headers handler
-	StatusCode    int         `json:"status_code,omitempty"`    // for static_response
+	FlushInterval   interface{}      `json:"flush_interval,omitempty"`   // for reverse_proxy
+	RequestBuffers  int64            `json:"request_buffers,omitempty"`  // for reverse_proxy
+	ResponseBuffers int64            `json:"response_buffers,omitempty"` // for reverse_proxy
+	HandleResponse  []HandleResponse `json:"handle_response,omitempty"`  // for reverse_proxy
+	ProxyRewrite    *ProxyRewrite    `json:"rewrite,omitempty"`          // for reverse_proxy
+	Headers       interface{} `json:"headers,omitempty"`        // for static_response (map[string][]string) and reverse_proxy (*Headers)
+	StatusCode    interface{} `json:"status_code,omitempty"`    // for static_response and file_server: a code or a placeholder
+	// Headers handler fields
+	Request  *HeaderOps `json:"request,omitempty"`  // for headers handler
+	Response *HeaderOps `json:"response,omitempty"` // for headers handler
 	// Encode (compression) handler fields
-	Encodings *EncodingsConfig `json:"encodings,omitempty"` // for encode handler
-	Prefer    []string         `json:"prefer,omitempty"`    // for encode handler
+	Encodings     *EncodingsConfig `json:"encodings,omitempty"`      // for encode handler
+	Prefer        []string         `json:"prefer,omitempty"`         // for encode handler
+	MinimumLength int              `json:"minimum_length,omitempty"` // for encode handler
 	// Rewrite handler fields
 	URI           string `json:"uri,omitempty"`            // for rewrite handler
 	StripPathPrefix string `json:"strip_path_prefix,omitempty"` // for rewrite handler
@@ -116,8 +184,13 @@ type Handler struct {
 
 // EncodingsConfig represents encoding configurations for the encode handler
 type EncodingsConfig struct {
-	Gzip *struct{} `json:"gzip,omitempty"`
-	Zstd *struct{} `json:"zstd,omitempty"`
+	Gzip *GzipEncoding `json:"gzip,omitempty"`
+	Zstd *struct{}     `json:"zstd,omitempty"`
+}
+
+// GzipEncoding represents gzip encoder options
+type GzipEncoding struct {
+	Level int `json:"level,omitempty"`
 }
 
 // RewriteSubstring represents a substring replacement for rewrite
@@ -126,6 +199,11 @@ type RewriteSubstring struct {
 	Replace string `json:"replace"`
 }
 
+// HashConfig represents the password hash algorithm for http_basic
+type HashConfig struct {
+	Algorithm string `json:"algorithm"`
+}
+
 // AuthProviders represents authentication providers
 type AuthProviders struct {
 	HTTP *HTTPBasicAuth `json:"http_basic,omitempty"`
@@ -133,6 +211,7 @@ type AuthProviders struct {
 
 // HTTPBasicAuth represents HTTP basic auth configuration
 type HTTPBasicAuth struct {
+	Hash     *HashConfig        `json:"hash,omitempty"`
 	Accounts []BasicAuthAccount `json:"accounts,omitempty"`
 	Realm    string             `json:"realm,omitempty"`
 }
@@ -140,7 +219,7 @@ type HTTPBasicAuth struct {
 // BasicAuthAccount represents a single basic auth account
 type BasicAuthAccount struct {
 	Username string `json:"username"`
-	Password string `json:"password"` // bcrypt hash
+	Password string `json:"password"` // base64-encoded bcrypt hash
 }
 
 // Upstream represents a reverse proxy upstream
@@ -157,13 +236,33 @@ type Headers struct {
 
 // HeaderOps represents header operations
 type HeaderOps struct {
-	Set    map[string][]string `json:"set,omitempty"`
-	Add    map[string][]string `json:"add,omitempty"`
-	Delete []string            `json:"delete,omitempty"`
+	Set      map[string][]string            `json:"set,omitempty"`
+	Add      map[string][]string            `json:"add,omitempty"`
+	Delete   []string                       `json:"delete,omitempty"`
+	Replace  map[string][]HeaderReplacement `json:"replace,omitempty"`
+	Deferred bool                           `json:"deferred,omitempty"` // response only: apply after the next handler writes headers
+}
+
+// HeaderReplacement represents a search/replace operation on a header value
+type HeaderReplacement struct {
+	Search  string `json:"search,omitempty"`
+	Replace string `json:"replace"`
+}
+
+// SiteMiddleware groups the per-site middleware records loaded from the database
+type SiteMiddleware struct {
+	Settings         *models.MiddlewareSettings
+	HeaderRules      []models.HeaderRule
+	AccessRules      []models.AccessRule
+	AuthUsers        []models.BasicAuthUser
+	RewriteRules     []models.RewriteRule
+	ErrorPages       []models.ErrorPage
+	HSTS             string     // Strict-Transport-Security value for TLS sites, from global settings
+	MaintenanceUntil *time.Time // end of the running maintenance window, if any
 }
 
 // BuildSiteConfig builds Caddy configuration for a site with its routes
-func (cb *ConfigBuilder) BuildSiteConfig(site *models.Site, routes []models.Route, redirectRules []models.RedirectRule, upstreamGroups map[string]*models.UpstreamGroup, upstreams map[string][]models.Upstream) (*HTTPServer, error) {
+func (cb *ConfigBuilder) BuildSiteConfig(site *models.Site, routes []models.Route, redirectRules []models.RedirectRule, middleware *SiteMiddleware, upstreamGroups map[string]*models.UpstreamGroup, upstreams map[string][]models.Upstream, certificates map[string]models.CustomCertificate, warnings *buildWarnings) (*HTTPServer, error) {
 	server := &HTTPServer{
 		Listen: []string{fmt.Sprintf(":%d", site.ListenPort)},
 		Routes: []Route{},
@@ -180,6 +279,60 @@ func (cb *ConfigBuilder) BuildSiteConfig(site *models.Site, routes []models.Rout
 		site.Hosts = hosts
 	}
 
+	// 0. Access Control (deny before anything else runs)
+	if middleware != nil && middleware.Settings != nil && middleware.Settings.AccessControlEnabled {
+		server.Routes = append(server.Routes, buildAccessRoutes(site, middleware.AccessRules, middleware.Settings.AccessControlDefault, warnings)...)
+	}
+
+	// 0a. Maintenance mode (503 for everyone outside the bypass list, ahead of all site routes)
+	if site.MaintenanceEnabled {
+		var until *time.Time
+		if middleware != nil {
+			until = middleware.MaintenanceUntil
+		}
+		server.Routes = append(server.Routes, buildMaintenanceRoute(site, until))
+	}
+
+	// 0b. Header Rules (non-terminal, so they apply to redirects and routes alike)
+	if middleware != nil {
+		if headerRoute := buildHeaderRulesRoute(site, middleware.HeaderRules, warnings); headerRoute != nil {
+			server.Routes = append(server.Routes, *headerRoute)
+		}
+	}
+
+	// 0c. Security headers and HSTS (after header rules, so those can override them)
+	if middleware != nil {
+		if securityRoute := buildSecurityHeadersRoute(site, middleware.Settings, middleware.HSTS); securityRoute != nil {
+			server.Routes = append(server.Routes, *securityRoute)
+		}
+	}
+
+	// 0d. CORS (before authentication, since browsers send preflights without credentials)
+	if middleware != nil && middleware.Settings != nil && middleware.Settings.CORSEnabled {
+		base := Match{}
+		if len(site.Hosts) > 0 {
+			base.Host = site.Hosts
+		}
+		server.Routes = append(server.Routes, buildCORSRoutes(corsFromSettings(middleware.Settings), base, site.ID)...)
+	}
+
+	// 0e. Basic Auth (non-terminal, guards everything below except excluded paths)
+	if middleware != nil && middleware.Settings != nil && middleware.Settings.BasicAuthEnabled {
+		server.Routes = append(server.Routes, buildBasicAuthRoute(site, middleware.Settings, middleware.AuthUsers))
+	}
+
+	// 0f. Forward Auth (non-terminal, an identity proxy authorizes everything below except excluded paths)
+	if middleware != nil && middleware.Settings != nil && middleware.Settings.ForwardAuthEnabled {
+		forwardAuthRoute, err := buildForwardAuthRoute(site, middleware.Settings)
+		if err != nil {
+			// Skipping the guard would expose the site, so refuse everything instead
+			warnings.add("site %s: forward auth: %v; denying all requests", site.Name, err)
+			server.Routes = append(server.Routes, buildDenyAllRoute(site, fmt.Sprintf("forward_auth_%s", site.ID), 503))
+		} else {
+			server.Routes = append(server.Routes, *forwardAuthRoute)
+		}
+	}
+
 	// 1. Redirect Rules (Priority High)
 	for _, rule := range redirectRules {
 		if !rule.Enabled {
@@ -201,27 +354,46 @@ func (cb *ConfigBuilder) BuildSiteConfig(site *models.Site, routes []models.Rout
 
 		// Handler
 		handler := Handler{
-			Handler: "static_response",
-			Headers: &Headers{
-				Response: &HeaderOps{
-					Set: map[string][]string{"Location": {rule.Destination}},
-				},
-			},
+			Handler:    "static_response",
+			Headers:    map[string][]string{"Location": {rule.Destination}},
 			StatusCode: rule.Code,
 		}
 		caddyRoute.Handle = []Handler{handler}
 		server.Routes = append(server.Routes, caddyRoute)
 	}
 
+	// 1b. Rewrite Rules (non-terminal, applied after redirects and before standard routes)
+	if middleware != nil {
+		for _, rule := range middleware.RewriteRules {
+			if !rule.Enabled {
+				continue
+			}
+			if err := ValidateRewriteRule(rule); err != nil {
+				warnings.add("site %s: skipped rewrite rule %s: %v", site.Name, rule.ID, err)
+				continue
+			}
+			server.Routes = append(server.Routes, buildRewriteRoute(site, rule))
+		}
+	}
+
+	// Site-level handlers that wrap every standard route's own handler
+	var siteChain []Handler
+	if middleware != nil && middleware.Settings != nil && middleware.Settings.CompressionEnabled {
+		if encode := buildEncodeHandler(middleware.Settings, warnings); encode != nil {
+			siteChain = append(siteChain, *encode)
+		}
+	}
+
 	// 2. Standard Routes
 	for _, route := range routes {
 		if !route.Enabled {
 			continue
 		}
 
+		// Middleware-only pipelines fall through so their handlers wrap the routes after them
 		caddyRoute := Route{
 			ID:       fmt.Sprintf("route_%s", route.ID),
-			Terminal: true,
+			Terminal: !isMiddlewareHandler(route.HandlerType),
 		}
 
 		// Build matchers
@@ -229,18 +401,37 @@ func (cb *ConfigBuilder) BuildSiteConfig(site *models.Site, routes []models.Rout
 		if len(site.Hosts) > 0 {
 			match.Host = site.Hosts
 		}
-		if route.PathMatcher != "" {
-			pathMatch := route.PathMatcher
-			// For file_server, use wildcard matching so all files are served
-			if route.HandlerType == "file_server" && (pathMatch == "/" || pathMatch == "") {
-				pathMatch = "/*"
+		switch route.MatchType {
+		case "path_prefix":
+			match.Path = normalizePathPrefix(route.PathMatcher)
+		case "path_regexp":
+			if route.PathMatcher != "" {
+				match.PathRegexp = &RegexpMatch{Name: regexpMatcherName("route", route.ID), Pattern: route.PathMatcher}
+			}
+		default:
+			if route.PathMatcher != "" {
+				pathMatch := route.PathMatcher
+				// For file_server and PHP, use wildcard matching so all files are served
+				if servesFiles(route.HandlerType) && pathMatch == "/" {
+					pathMatch = "/*"
+				}
+				match.Path = []string{pathMatch}
+			} else if servesFiles(route.HandlerType) {
+				// Default to wildcard for file_server and PHP with no path specified
+				match.Path = []string{"/*"}
 			}
-			match.Path = []string{pathMatch}
-		} else if route.HandlerType == "file_server" {
-			// Default to wildcard for file_server with no path specified
-			match.Path = []string{"/*"}
 		}
 		
+		// Additional request matchers
+		if route.MatchersJSON != "" {
+			var matchers models.RouteMatchers
+			if err := json.Unmarshal([]byte(route.MatchersJSON), &matchers); err != nil {
+				warnings.add("site %s: skipped route %s: invalid matchers: %v", site.Name, route.ID, err)
+				continue
+			}
+			applyRouteMatchers(&match, &matchers, regexpMatcherName("route", route.ID))
+		}
+
 		// Parse methods
 		if route.MethodsJSON != "" {
 			var methods []string
@@ -251,34 +442,377 @@ func (cb *ConfigBuilder) BuildSiteConfig(site *models.Site, routes []models.Rout
 		
 		caddyRoute.Match = []Match{match}
 
-		// Build handler based on type
-		handler, err := cb.buildHandler(route, upstreamGroups, upstreams)
+		// Build the handler pipeline
+		handlers, err := cb.buildHandlers(route, upstreamGroups, upstreams, certificates, warnings)
 		if err != nil {
-			return nil, err
+			warnings.add("site %s: skipped %v", site.Name, err)
+			continue
 		}
-		caddyRoute.Handle = []Handler{handler}
+		caddyRoute.Handle = append(append([]Handler{}, siteChain...), handlers...)
 
 		server.Routes = append(server.Routes, caddyRoute)
 	}
 
+	// 3. Error pages, run by Caddy when a handler above fails
+	if middleware != nil {
+		if errorRoutes := buildErrorRoutes(site, middleware.ErrorPages, warnings); len(errorRoutes) > 0 {
+			server.Errors = &HTTPErrorConfig{Routes: errorRoutes}
+		}
+	}
+
 	return server, nil
 }
 
-// buildHandler creates a Caddy handler from a route model
-func (cb *ConfigBuilder) buildHandler(route models.Route, upstreamGroups map[string]*models.UpstreamGroup, upstreams map[string][]models.Upstream) (Handler, error) {
-	handler := Handler{
-		Handler: route.HandlerType,
+// buildRewriteRoute creates a non-terminal rewrite route for a rewrite rule.
+// prefix replaces the matched prefix, exact replaces the whole path and regexp
+// substitutes $1 / ${name} capture groups into the replacement. StripPrefix, if set,
+// is removed from the rewritten path afterwards.
+func buildRewriteRoute(site *models.Site, rule models.RewriteRule) Route {
+	match := Match{}
+	if len(site.Hosts) > 0 {
+		match.Host = site.Hosts
 	}
 
-	// Parse handler config
-	var config map[string]interface{}
-	if route.HandlerConfig != "" {
-		if err := json.Unmarshal([]byte(route.HandlerConfig), &config); err != nil {
-			return handler, err
+	var handlers []Handler
+	switch rule.MatchType {
+	case "exact":
+		match.Path = []string{rule.Pattern}
+		handlers = append(handlers, Handler{Handler: "rewrite", URI: rule.Replacement})
+	case "regexp":
+		name := regexpMatcherName("rewrite", rule.ID)
+		match.PathRegexp = &RegexpMatch{Name: name, Pattern: rule.Pattern}
+		handlers = append(handlers, Handler{Handler: "rewrite", URI: expandCaptureGroups(rule.Replacement, name)})
+	default: // prefix
+		prefix := strings.TrimSuffix(rule.Pattern, "/")
+		match.Path = normalizePathPrefix(prefix)
+		// Strip the matched prefix, then prepend the replacement to what is left
+		handlers = append(handlers,
+			Handler{Handler: "rewrite", StripPathPrefix: prefix},
+			Handler{Handler: "rewrite", URI: strings.TrimSuffix(rule.Replacement, "/") + "{http.request.uri.path}"},
+		)
+	}
+
+	if rule.StripPrefix != "" {
+		handlers = append(handlers, Handler{Handler: "rewrite", StripPathPrefix: rule.StripPrefix})
+	}
+
+	return Route{
+		ID:     fmt.Sprintf("rewrite_%s", rule.ID),
+		Match:  []Match{match},
+		Handle: handlers,
+	}
+}
+
+// regexpMatcherName builds a placeholder-safe name for a path_regexp matcher
+func regexpMatcherName(prefix, id string) string {
+	return prefix + "_" + strings.ReplaceAll(id, "-", "")
+}
+
+// captureGroupPattern matches $1, ${1} and ${name} references in a replacement string
+var captureGroupPattern = regexp.MustCompile(`\$(\d+|\{\w+\})`)
+
+// expandCaptureGroups converts $1 / ${name} references into Caddy regexp placeholders
+func expandCaptureGroups(replacement, matcherName string) string {
+	return captureGroupPattern.ReplaceAllStringFunc(replacement, func(ref string) string {
+		group := strings.Trim(ref[1:], "{}")
+		return fmt.Sprintf("{http.regexp.%s.%s}", matcherName, group)
+	})
+}
+
+// applyRouteMatchers copies a route's request matchers into a Caddy matcher set.
+// namePrefix keeps header_regexp matcher names unique for placeholders.
+func applyRouteMatchers(match *Match, m *models.RouteMatchers, namePrefix string) {
+	if len(m.Header) > 0 {
+		match.Header = m.Header
+	}
+	if len(m.HeaderRegexp) > 0 {
+		match.HeaderRegexp = make(map[string]*RegexpMatch)
+		for header, pattern := range m.HeaderRegexp {
+			name := namePrefix + "_" + strings.ReplaceAll(strings.ToLower(header), "-", "_")
+			match.HeaderRegexp[header] = &RegexpMatch{Name: name, Pattern: pattern}
+		}
+	}
+	if len(m.Query) > 0 {
+		match.Query = m.Query
+	}
+	if len(m.RemoteIP) > 0 {
+		match.RemoteIP = &IPRangeMatch{Ranges: m.RemoteIP}
+	}
+	if len(m.ClientIP) > 0 {
+		match.ClientIP = &IPRangeMatch{Ranges: m.ClientIP}
+	}
+	match.Protocol = m.Protocol
+	match.Expression = m.Expression
+	if m.Not != nil {
+		not := Match{}
+		applyRouteMatchers(&not, m.Not, namePrefix+"_not")
+		match.Not = append(match.Not, not)
+	}
+}
+
+// normalizePathPrefix expands a prefix so that "/api", "/api/" and "/api/*"
+// all match both /api itself and everything below it
+func normalizePathPrefix(prefix string) []string {
+	prefix = strings.TrimSuffix(strings.TrimSuffix(prefix, "*"), "/")
+	if prefix == "" {
+		return []string{"/*"}
+	}
+	return []string{prefix, prefix + "/*"}
+}
+
+// servesFiles reports whether a handler type serves a directory tree and should match all paths by default
+func servesFiles(handlerType string) bool {
+	return handlerType == "file_server" || handlerType == "php_fastcgi"
+}
+
+// isMiddlewareHandler reports whether a handler type passes requests on to the next handler
+func isMiddlewareHandler(handlerType string) bool {
+	switch handlerType {
+	case "encode", "headers", "rewrite", "authentication", "forward_auth", "cors":
+		return true
+	}
+	return false
+}
+
+// buildEncodeHandler creates the site-level encode handler from middleware settings.
+// CompressionTypes lists the encoders in preference order, e.g. "zstd,gzip".
+func buildEncodeHandler(settings *models.MiddlewareSettings, warnings *buildWarnings) *Handler {
+	handler := &Handler{
+		Handler:       "encode",
+		Encodings:     &EncodingsConfig{},
+		MinimumLength: settings.CompressionMinLength,
+	}
+
+	for _, encoding := range ParseCompressionTypes(settings.CompressionTypes) {
+		switch encoding {
+		case "gzip":
+			if handler.Encodings.Gzip == nil {
+				handler.Encodings.Gzip = &GzipEncoding{Level: settings.CompressionLevel}
+				handler.Prefer = append(handler.Prefer, encoding)
+			}
+		case "zstd":
+			if handler.Encodings.Zstd == nil {
+				handler.Encodings.Zstd = &struct{}{}
+				handler.Prefer = append(handler.Prefer, encoding)
+			}
+		default:
+			warnings.add("skipped unsupported compression type %q", encoding)
 		}
 	}
 
-	switch route.HandlerType {
+	if len(handler.Prefer) == 0 {
+		return nil
+	}
+	return handler
+}
+
+// buildAccessRoutes compiles access rules into terminal 403 routes.
+// Rules are expected in descending priority order and the first matching rule wins:
+// a deny rule only applies to clients not already allowed by a higher priority rule,
+// and with a "deny" default policy every client outside the allow list is rejected.
+func buildAccessRoutes(site *models.Site, rules []models.AccessRule, defaultPolicy string, warnings *buildWarnings) []Route {
+	var routes []Route
+	var allowed []string
+
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		err := ValidateAccessRule(rule)
+		if rule.RuleType == "allow" {
+			// Skipping an allow rule only narrows access
+			if err != nil {
+				warnings.add("site %s: skipped access rule %s: %v", site.Name, rule.ID, err)
+			} else {
+				allowed = append(allowed, rule.CIDR)
+			}
+			continue
+		}
+
+		// A deny rule that cannot be built denies every client it could have matched,
+		// so it fails closed instead of opening the site
+		match := Match{}
+		if err != nil {
+			warnings.add("site %s: access rule %s: %v; denying all clients not allowed by a higher priority rule", site.Name, rule.ID, err)
+		} else {
+			match.ClientIP = &IPRangeMatch{Ranges: []string{rule.CIDR}}
+		}
+		if len(site.Hosts) > 0 {
+			match.Host = site.Hosts
+		}
+		if len(allowed) > 0 {
+			match.Not = []Match{{ClientIP: &IPRangeMatch{Ranges: append([]string(nil), allowed...)}}}
+		}
+		routes = append(routes, Route{
+			ID:       fmt.Sprintf("access_%s", rule.ID),
+			Match:    []Match{match},
+			Handle:   []Handler{{Handler: "static_response", StatusCode: 403}},
+			Terminal: true,
+		})
+	}
+
+	if defaultPolicy == "deny" {
+		match := Match{}
+		if len(site.Hosts) > 0 {
+			match.Host = site.Hosts
+		}
+		if len(allowed) > 0 {
+			match.Not = []Match{{ClientIP: &IPRangeMatch{Ranges: allowed}}}
+		}
+		routes = append(routes, Route{
+			ID:       fmt.Sprintf("access_default_%s", site.ID),
+			Match:    []Match{match},
+			Handle:   []Handler{{Handler: "static_response", StatusCode: 403}},
+			Terminal: true,
+		})
+	}
+
+	return routes
+}
+
+// buildDenyAllRoute creates a terminal route answering every request to the site with
+// the given status, used when a guard of the site cannot be built
+func buildDenyAllRoute(site *models.Site, id string, status int) Route {
+	match := Match{}
+	if len(site.Hosts) > 0 {
+		match.Host = site.Hosts
+	}
+	return Route{
+		ID:       id,
+		Match:    []Match{match},
+		Handle:   []Handler{{Handler: "static_response", StatusCode: status}},
+		Terminal: true,
+	}
+}
+
+// buildBasicAuthRoute creates a non-terminal http_basic authentication route for a site.
+// Caddy expects the bcrypt hashes base64-encoded in JSON.
+func buildBasicAuthRoute(site *models.Site, settings *models.MiddlewareSettings, users []models.BasicAuthUser) Route {
+	realm := settings.BasicAuthRealm
+	if realm == "" {
+		realm = "Restricted"
+	}
+
+	basicAuth := &HTTPBasicAuth{
+		Hash:  &HashConfig{Algorithm: "bcrypt"},
+		Realm: realm,
+	}
+	for _, user := range users {
+		if !user.Enabled {
+			continue
+		}
+		basicAuth.Accounts = append(basicAuth.Accounts, BasicAuthAccount{
+			Username: user.Username,
+			Password: base64.StdEncoding.EncodeToString([]byte(user.PasswordHash)),
+		})
+	}
+
+	match := Match{}
+	if len(site.Hosts) > 0 {
+		match.Host = site.Hosts
+	}
+	var exclude []string
+	if settings.BasicAuthExcludeJSON != "" {
+		json.Unmarshal([]byte(settings.BasicAuthExcludeJSON), &exclude)
+	}
+	if len(exclude) > 0 {
+		match.Not = []Match{{Path: exclude}}
+	}
+
+	return Route{
+		ID:    fmt.Sprintf("auth_%s", site.ID),
+		Match: []Match{match},
+		Handle: []Handler{{
+			Handler:   "authentication",
+			Providers: &AuthProviders{HTTP: basicAuth},
+		}},
+	}
+}
+
+// buildHeaderRulesRoute creates a non-terminal route applying the site's header rules.
+// Rules are expected in ascending priority order; each becomes its own headers handler,
+// so a higher priority rule is applied later and wins when two rules touch the same header.
+func buildHeaderRulesRoute(site *models.Site, rules []models.HeaderRule, warnings *buildWarnings) *Route {
+	var handlers []Handler
+	for _, rule := range rules {
+		if !rule.Enabled {
+			continue
+		}
+		if err := ValidateHeaderRule(rule); err != nil {
+			warnings.add("site %s: skipped header rule %s: %v", site.Name, rule.ID, err)
+			continue
+		}
+
+		ops := &HeaderOps{}
+		switch rule.Operation {
+		case "set":
+			ops.Set = map[string][]string{rule.HeaderName: {rule.HeaderValue}}
+		case "add":
+			ops.Add = map[string][]string{rule.HeaderName: {rule.HeaderValue}}
+		case "delete":
+			ops.Delete = []string{rule.HeaderName}
+		case "replace":
+			ops.Replace = map[string][]HeaderReplacement{
+				rule.HeaderName: {{Search: rule.HeaderValue, Replace: rule.Replace}},
+			}
+		}
+
+		handler := Handler{Handler: "headers"}
+		if rule.Direction == "response" {
+			// Defer so upstream response headers are already present when we modify them
+			ops.Deferred = true
+			handler.Response = ops
+		} else {
+			handler.Request = ops
+		}
+		handlers = append(handlers, handler)
+	}
+
+	if len(handlers) == 0 {
+		return nil
+	}
+
+	match := Match{}
+	if len(site.Hosts) > 0 {
+		match.Host = site.Hosts
+	}
+	return &Route{
+		ID:     fmt.Sprintf("headers_%s", site.ID),
+		Match:  []Match{match},
+		Handle: handlers,
+	}
+}
+
+// buildHandlers creates the full handler chain for a route from its pipeline steps
+func (cb *ConfigBuilder) buildHandlers(route models.Route, upstreamGroups map[string]*models.UpstreamGroup, upstreams map[string][]models.Upstream, certificates map[string]models.CustomCertificate, warnings *buildWarnings) ([]Handler, error) {
+	// File-serving steps without a root serve the site's managed files
+	defaultRoot := SiteRoot(route.SiteID)
+
+	steps, err := route.HandlerSteps()
+	if err != nil {
+		return nil, fmt.Errorf("invalid handlers for route %s: %w", route.ID, err)
+	}
+
+	var handlers []Handler
+	for _, step := range steps {
+		handler, err := cb.buildHandler(step, defaultRoot, upstreamGroups, upstreams, certificates, warnings)
+		if err != nil {
+			return nil, fmt.Errorf("route %s (%s): %w", route.ID, step.Type, err)
+		}
+		handlers = append(handlers, handler)
+	}
+	return handlers, nil
+}
+
+// buildHandler creates a Caddy handler from a single pipeline step
+func (cb *ConfigBuilder) buildHandler(step models.HandlerStep, defaultRoot string, upstreamGroups map[string]*models.UpstreamGroup, upstreams map[string][]models.Upstream, certificates map[string]models.CustomCertificate, warnings *buildWarnings) (Handler, error) {
+	handler := Handler{
+		Handler: step.Type,
+	}
+
+	config := step.Config
+
+	switch step.Type {
 	case "static_response":
 		if body, ok := config["body"].(string); ok {
 			handler.Body = body
@@ -288,58 +822,45 @@ func (cb *ConfigBuilder) buildHandler(route models.Route, upstreamGroups map[str
 		}
 
 	case "file_server":
-		if root, ok := config["root"].(string); ok {
-			handler.Root = root
-		}
-		if browse, ok := config["browse"].(bool); ok && browse {
-			handler.Browse = struct{}{}
+		if err := buildFileServer(&handler, config, defaultRoot); err != nil {
+			return handler, fmt.Errorf("file_server: %w", err)
 		}
 
 	case "reverse_proxy":
-		// Get upstream group or direct upstreams
-		if groupName, ok := config["upstream_group"].(string); ok {
-			if group, exists := upstreamGroups[groupName]; exists {
-				if groupUpstreams, exists := upstreams[groupName]; exists {
-					for _, u := range groupUpstreams {
-						if u.Enabled {
-							handler.Upstreams = append(handler.Upstreams, Upstream{
-								Dial:        u.Address,
-								MaxRequests: u.MaxRequests,
-							})
-						}
-					}
-				}
-				
-				// Set load balancing policy
-				if group.LoadBalancing != "" {
-					handler.LoadBalancing = map[string]interface{}{
-						"selection_policy": map[string]interface{}{
-							"policy": group.LoadBalancing,
-						},
-					}
-				}
-			}
-		} else if upstreamAddrs, ok := config["upstreams"].([]interface{}); ok {
-			for _, addr := range upstreamAddrs {
-				if a, ok := addr.(string); ok {
-					handler.Upstreams = append(handler.Upstreams, Upstream{Dial: a})
-				}
-			}
+		if err := buildReverseProxy(&handler, config, upstreamGroups, upstreams, certificates, warnings); err != nil {
+			return handler, err
+		}
+
+	case "forward_auth":
+		// Expands into a reverse_proxy to the auth service like the Caddyfile directive
+		forwardAuth, err := buildForwardAuthHandler(forwardAuthFromStep(config))
+		if err != nil {
+			return handler, fmt.Errorf("forward_auth: %w", err)
 		}
+		handler = forwardAuth
 
-		// Transport configuration
-		if transport, ok := config["transport"].(map[string]interface{}); ok {
-			handler.Transport = transport
+	case "cors":
+		// Preflights are answered inside the subroute, other requests continue down the pipeline
+		policy := corsFromStep(config)
+		if err := ValidateCORSPolicy(policy); err != nil {
+			return handler, fmt.Errorf("cors: %w", err)
 		}
+		handler.Handler = "subroute"
+		handler.Routes = buildCORSRoutes(policy, Match{}, "")
+
+	case "php_fastcgi":
+		// Expands into a subroute like the Caddyfile directive
+		routes, err := buildPHPFastCGIRoutes(config, defaultRoot)
+		if err != nil {
+			return handler, fmt.Errorf("php_fastcgi: %w", err)
+		}
+		handler.Handler = "subroute"
+		handler.Routes = routes
 
 	case "redirect":
 		handler.Handler = "static_response"
 		if location, ok := config["location"].(string); ok {
-			handler.Headers = &Headers{
-				Response: &HeaderOps{
-					Set: map[string][]string{"Location": {location}},
-				},
-			}
+			handler.Headers = map[string][]string{"Location": {location}}
 		}
 		if statusCode, ok := config["status_code"].(float64); ok {
 			handler.StatusCode = int(statusCode)
@@ -351,14 +872,14 @@ func (cb *ConfigBuilder) buildHandler(route models.Route, upstreamGroups map[str
 		// Compression handler
 		handler.Encodings = &EncodingsConfig{}
 		if gzip, ok := config["gzip"].(bool); ok && gzip {
-			handler.Encodings.Gzip = &struct{}{}
+			handler.Encodings.Gzip = &GzipEncoding{}
 		}
 		if zstd, ok := config["zstd"].(bool); ok && zstd {
 			handler.Encodings.Zstd = &struct{}{}
 		}
 		// Default to gzip if no specific encoding is set
 		if handler.Encodings.Gzip == nil && handler.Encodings.Zstd == nil {
-			handler.Encodings.Gzip = &struct{}{}
+			handler.Encodings.Gzip = &GzipEncoding{}
 		}
 		if prefer, ok := config["prefer"].([]interface{}); ok {
 			for _, p := range prefer {
@@ -379,12 +900,11 @@ func (cb *ConfigBuilder) buildHandler(route models.Route, upstreamGroups map[str
 
 	case "headers":
 		// Standalone headers handler
-		handler.Headers = &Headers{}
 		if request, ok := config["request"].(map[string]interface{}); ok {
-			handler.Headers.Request = parseHeaderOps(request)
+			handler.Request = parseHeaderOps(request)
 		}
 		if response, ok := config["response"].(map[string]interface{}); ok {
-			handler.Headers.Response = parseHeaderOps(response)
+			handler.Response = parseHeaderOps(response)
 		}
 
 	case "authentication":
@@ -468,12 +988,20 @@ type TLSApp struct {
 }
 
 type TLSAutomation struct {
-	Policies []TLSPolicy `json:"policies,omitempty"`
+	Policies []TLSPolicy      `json:"policies,omitempty"`
+	OnDemand *OnDemandConfig `json:"on_demand,omitempty"`
+}
+
+// OnDemandConfig gates on-demand issuance behind a permission module
+type OnDemandConfig struct {
+	Permission map[string]interface{} `json:"permission,omitempty"`
 }
 
 type TLSPolicy struct {
 	Subjects []string      `json:"subjects,omitempty"`
 	Issuers  []interface{} `json:"issuers,omitempty"`
+	KeyType  string        `json:"key_type,omitempty"`
+	OnDemand bool          `json:"on_demand,omitempty"`
 }
 
 // PEMCertKeyPair represents a certificate key pair for load_pem
@@ -484,23 +1012,37 @@ type PEMCertKeyPair struct {
 }
 
 // BuildFullConfig builds the complete Caddy configuration from database models
-func (cb *ConfigBuilder) BuildFullConfig(sites []models.Site, routes map[string][]models.Route, redirectRules map[string][]models.RedirectRule, upstreamGroups map[string]*models.UpstreamGroup, upstreams map[string][]models.Upstream, certificates []models.CustomCertificate, settings *models.GlobalSettings, tlsConfigs map[string]models.TLSConfig, dnsProviders map[string]models.DNSProvider) (*CaddyConfig, error) {
+func (cb *ConfigBuilder) BuildFullConfig(sites []models.Site, routes map[string][]models.Route, redirectRules map[string][]models.RedirectRule, middleware map[string]*SiteMiddleware, upstreamGroups map[string]*models.UpstreamGroup, upstreams map[string][]models.Upstream, certificates []models.CustomCertificate, settings *models.GlobalSettings, tlsConfigs map[string]models.TLSConfig, dnsProviders map[string]models.DNSProvider, serverSettings map[string]models.ServerSettings) (*CaddyConfig, error) {
 	config := &CaddyConfig{
 		Admin: &AdminConfig{
 			Listen: "localhost:2019",
 		},
 		Apps: make(map[string]interface{}),
 	}
+	warnings := &buildWarnings{}
 
 	httpApp := &HTTPApp{
 		Servers: make(map[string]*HTTPServer),
 	}
 
+	// Decode hosts up front; TLS policies below need them before servers are built
+	for i := range sites {
+		if len(sites[i].Hosts) == 0 && sites[i].HostsJSON != "" {
+			json.Unmarshal([]byte(sites[i].HostsJSON), &sites[i].Hosts)
+		}
+	}
+
 	// TLS App configuration
 	tlsApp := TLSApp{
 		Certificates: make(map[string]interface{}),
 	}
 
+	// Certificates by ID, for site bindings and upstream root CAs
+	certificatesByID := make(map[string]models.CustomCertificate)
+	for _, cert := range certificates {
+		certificatesByID[cert.ID] = cert
+	}
+
 	// 1. Custom Certificates
 	if len(certificates) > 0 {
 		
@@ -509,72 +1051,77 @@ func (cb *ConfigBuilder) BuildFullConfig(sites []models.Site, routes map[string]
 			pemLoader = append(pemLoader, PEMCertKeyPair{
 				Certificate: cert.CertPEM,
 				Key:         cert.KeyPEM,
-				Tags:        []string{cert.Name}, // Use name as tag for easier debugging
+				Tags:        []string{cert.Name, certificateTag(cert.ID)}, // Name for easier debugging, ID for selection
 			})
 		}
 		
 		tlsApp.Certificates["load_pem"] = pemLoader
 	}
 
-	// 2. DNS Automation Policies
-	var policies []TLSPolicy
+	// Certificate files configured on sites
+	var fileLoader []PEMFilePair
 	for _, site := range sites {
 		tlsConfig, ok := tlsConfigs[site.ID]
-		if !ok || !tlsConfig.WildcardCert || tlsConfig.DNSProviderID == "" {
+		if !ok || !site.Enabled || tlsConfig.CertificateID != "" || tlsConfig.CustomCertPath == "" || tlsConfig.CustomKeyPath == "" {
 			continue
 		}
+		fileLoader = append(fileLoader, PEMFilePair{
+			Certificate: tlsConfig.CustomCertPath,
+			Key:         tlsConfig.CustomKeyPath,
+			Tags:        []string{siteCertificateTag(&site, &tlsConfig, certificatesByID)},
+		})
+	}
+	if len(fileLoader) > 0 {
+		tlsApp.Certificates["load_files"] = fileLoader
+	}
 
-		// Find DNS Provider
-		provider, ok := dnsProviders[tlsConfig.DNSProviderID]
-		if !ok {
+	// 2. ACME Automation Policies
+	var policies []TLSPolicy
+	for _, site := range sites {
+		if !site.Enabled || !site.AutoHTTPS || !siteUsesTLS(&site, settings) {
 			continue
 		}
 
-		// Parse Credentials
-		var creds map[string]interface{}
-		if err := json.Unmarshal([]byte(provider.Credentials), &creds); err != nil {
-			fmt.Printf("Error parsing credentials for provider %s: %v\n", provider.Name, err)
-			continue
+		var tlsConfig *models.TLSConfig
+		if tc, ok := tlsConfigs[site.ID]; ok {
+			if !tc.AutoHTTPS {
+				continue
+			}
+			tlsConfig = &tc
 		}
 
-		// Construct Provider Config
-		providerConfig := map[string]interface{}{
-			"name": provider.Provider,
-		}
-		// Merge credentials into provider config
-		for k, v := range creds {
-			providerConfig[k] = v
+		// Sites bound to a custom certificate are not managed by ACME
+		if siteCertificateTag(&site, tlsConfig, certificatesByID) != "" {
+			continue
 		}
 
-		// Create Policy
-		policy := TLSPolicy{
-			Subjects: site.Hosts, // Apply to all hosts in site (should include *.domain)
-			Issuers: []interface{}{
-				map[string]interface{}{
-					"module": "acme",
-					"challenges": map[string]interface{}{
-						"dns": map[string]interface{}{
-							"provider": providerConfig,
-						},
-					},
-				},
-				map[string]interface{}{
-					"module": "zerossl",
-					"challenges": map[string]interface{}{
-						"dns": map[string]interface{}{
-							"provider": providerConfig,
-						},
-					},
-				},
-			},
+		policy, err := buildAutomationPolicy(&site, tlsConfig, settings, dnsProviders)
+		if err != nil {
+			warnings.add("site %s: skipped automation policy: %v", site.Name, err)
+			continue
+		}
+		if policy != nil {
+			policies = append(policies, *policy)
 		}
-		policies = append(policies, policy)
 	}
 
 	if len(policies) > 0 {
 		tlsApp.Automation = &TLSAutomation{
 			Policies: policies,
 		}
+
+		// On-demand issuance asks CaddyAdmin whether a hostname belongs to a site
+		for _, policy := range policies {
+			if policy.OnDemand {
+				tlsApp.Automation.OnDemand = &OnDemandConfig{
+					Permission: map[string]interface{}{
+						"module":   "http",
+						"endpoint": onDemandAskURL(settings),
+					},
+				}
+				break
+			}
+		}
 	}
 	
 	if len(tlsApp.Certificates) > 0 || tlsApp.Automation != nil {
@@ -600,25 +1147,15 @@ func (cb *ConfigBuilder) BuildFullConfig(sites []models.Site, routes map[string]
 		}
 	}
 
-	// Build each server from sites
-	for _, site := range sites {
-		if !site.Enabled {
-			continue
-		}
-
-		siteRoutes := routes[site.ID]
-		siteRedirects := redirectRules[site.ID]
-		serverName := strings.ReplaceAll(site.Name, ".", "_")
-		
-		server, err := cb.BuildSiteConfig(&site, siteRoutes, siteRedirects, upstreamGroups, upstreams)
-		if err != nil {
-			return nil, fmt.Errorf("failed to build config for site %s: %w", site.Name, err)
-		}
-
-		httpApp.Servers[serverName] = server
+	// Build one server per listen address from sites
+	servers, err := cb.buildServers(sites, routes, redirectRules, middleware, upstreamGroups, upstreams, certificatesByID, settings, tlsConfigs, serverSettings, warnings)
+	if err != nil {
+		return nil, err
 	}
+	httpApp.Servers = servers
 
 	config.Apps["http"] = httpApp
+	config.Warnings = warnings.messages
 
 	return config, nil
 }
@@ -661,6 +1198,39 @@ func (cb *ConfigBuilder) BuildFromDB() (*CaddyConfig, error) {
 		redirectsMap[site.ID] = rules
 	}
 
+	// 3b. Get Middleware Settings and Rules
+	middlewareMap := make(map[string]*SiteMiddleware)
+	for _, site := range sites {
+		mw := &SiteMiddleware{}
+
+		var settings models.MiddlewareSettings
+		if err := db.Where("site_id = ?", site.ID).First(&settings).Error; err == nil {
+			mw.Settings = &settings
+		}
+
+		// Header rules: ascending priority = application order
+		db.Where("site_id = ? AND enabled = ?", site.ID, true).Order("priority ASC, created_at ASC").Find(&mw.HeaderRules)
+
+		// Access rules: descending priority = first match wins
+		db.Where("site_id = ? AND enabled = ?", site.ID, true).Order("priority DESC, created_at ASC").Find(&mw.AccessRules)
+
+		db.Where("site_id = ? AND enabled = ?", site.ID, true).Order("username ASC").Find(&mw.AuthUsers)
+
+		// Rewrite rules: descending priority, like redirects
+		db.Where("site_id = ? AND enabled = ?", site.ID, true).Order("priority DESC, created_at ASC").Find(&mw.RewriteRules)
+
+		// Error pages: descending priority = first match wins
+		db.Where("site_id = ? AND enabled = ?", site.ID, true).Order("priority DESC, created_at ASC").Find(&mw.ErrorPages)
+
+		// Maintenance window currently holding the site in maintenance, for Retry-After
+		var window models.MaintenanceWindow
+		if err := db.Where("site_id = ? AND status = ?", site.ID, "active").Order("ends_at DESC").First(&window).Error; err == nil {
+			mw.MaintenanceUntil = &window.EndsAt
+		}
+
+		middlewareMap[site.ID] = mw
+	}
+
 	// 4. Get Upstream Groups
 	var upstreamGroups []models.UpstreamGroup
 	db.Find(&upstreamGroups)
@@ -697,6 +1267,14 @@ func (cb *ConfigBuilder) BuildFromDB() (*CaddyConfig, error) {
 		dnsProvidersMap[dp.ID] = dp
 	}
 
+	// 9. Get Server Settings
+	var serverSettingsList []models.ServerSettings
+	db.Find(&serverSettingsList)
+	serverSettingsMap := make(map[string]models.ServerSettings)
+	for _, ss := range serverSettingsList {
+		serverSettingsMap[ss.SiteID] = ss
+	}
+
 	// Build Config
-	return cb.BuildFullConfig(sites, routesMap, redirectsMap, groupsMap, upstreamsMap, certificates, &settings, tlsConfigsMap, dnsProvidersMap)
+	return cb.BuildFullConfig(sites, routesMap, redirectsMap, middlewareMap, groupsMap, upstreamsMap, certificates, &settings, tlsConfigsMap, dnsProvidersMap, serverSettingsMap)
 }
@@ -0,0 +1,37 @@
+package caddy
+
+import (
+	"caddyadmin/models"
+	"reflect"
+	"testing"
+)
+
+func TestBuildAccessRoutesInvalidRules(t *testing.T) {
+	site := &models.Site{ID: "s1", Name: "example", Hosts: []string{"example.com"}}
+	rules := []models.AccessRule{
+		{ID: "office", RuleType: "allow", CIDR: "10.0.0.0/8", Enabled: true},
+		{ID: "broken-allow", RuleType: "allow", CIDR: "10.0.0.0/33", Enabled: true},
+		{ID: "broken-deny", RuleType: "deny", CIDR: "not-an-ip", Enabled: true},
+	}
+
+	warnings := &buildWarnings{}
+	routes := buildAccessRoutes(site, rules, "allow", warnings)
+
+	// The broken allow rule is dropped, the broken deny rule rejects everyone not allowed above it
+	if len(routes) != 1 {
+		t.Fatalf("routes = %+v, want one deny route", routes)
+	}
+	match := routes[0].Match[0]
+	if routes[0].ID != "access_broken-deny" || match.ClientIP != nil {
+		t.Errorf("route = %+v, want access_broken-deny matching every client", routes[0])
+	}
+	if !reflect.DeepEqual(match.Host, site.Hosts) {
+		t.Errorf("host = %v, want %v", match.Host, site.Hosts)
+	}
+	if len(match.Not) != 1 || !reflect.DeepEqual(match.Not[0].ClientIP.Ranges, []string{"10.0.0.0/8"}) {
+		t.Errorf("not = %+v, want the higher priority allow list exempted", match.Not)
+	}
+	if len(warnings.messages) != 2 {
+		t.Errorf("warnings = %q, want one per broken rule", warnings.messages)
+	}
+}
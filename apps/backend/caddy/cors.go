@@ -0,0 +1,207 @@
+package caddy
+
+import (
+	"caddyadmin/models"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// CORSPolicy describes which cross-origin requests a site or route accepts
+type CORSPolicy struct {
+	AllowedOrigins   []string `json:"allowed_origins"` // exact ("https://app.example.com"), wildcard ("https://*.example.com") or "*"
+	AllowedMethods   []string `json:"allowed_methods"` // defaults to GET, POST, PUT, PATCH, DELETE
+	AllowedHeaders   []string `json:"allowed_headers"` // defaults to Content-Type, Authorization
+	ExposedHeaders   []string `json:"exposed_headers"`
+	AllowCredentials bool     `json:"allow_credentials"`
+	MaxAge           int      `json:"max_age"` // seconds browsers may cache a preflight
+}
+
+var (
+	defaultCORSMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE"}
+	defaultCORSHeaders = []string{"Content-Type", "Authorization"}
+)
+
+// corsOriginPattern matches scheme://host[:port] with an optional leading "*." label
+var corsOriginPattern = regexp.MustCompile(`^https?://(\*\.)?[a-zA-Z0-9.-]+(:[0-9]+)?$`)
+
+// corsFromStep reads a cors step config
+func corsFromStep(config map[string]interface{}) CORSPolicy {
+	policy := CORSPolicy{
+		AllowedOrigins: stringList(config["allowed_origins"]),
+		AllowedMethods: stringList(config["allowed_methods"]),
+		AllowedHeaders: stringList(config["allowed_headers"]),
+		ExposedHeaders: stringList(config["exposed_headers"]),
+	}
+	policy.AllowCredentials, _ = config["allow_credentials"].(bool)
+	if maxAge, ok := config["max_age"].(float64); ok {
+		policy.MaxAge = int(maxAge)
+	}
+	return policy
+}
+
+// corsFromSettings reads a site's CORS settings
+func corsFromSettings(settings *models.MiddlewareSettings) CORSPolicy {
+	policy := CORSPolicy{
+		AllowCredentials: settings.CORSAllowCredentials,
+		MaxAge:           settings.CORSMaxAge,
+	}
+	for _, field := range []struct {
+		column string
+		list   *[]string
+	}{
+		{settings.CORSAllowedOriginsJSON, &policy.AllowedOrigins},
+		{settings.CORSAllowedMethodsJSON, &policy.AllowedMethods},
+		{settings.CORSAllowedHeadersJSON, &policy.AllowedHeaders},
+		{settings.CORSExposedHeadersJSON, &policy.ExposedHeaders},
+	} {
+		if field.column != "" {
+			json.Unmarshal([]byte(field.column), field.list)
+		}
+	}
+	return policy
+}
+
+// anyOrigin reports whether the policy accepts every origin
+func (p CORSPolicy) anyOrigin() bool {
+	for _, origin := range p.AllowedOrigins {
+		if origin == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// originMatches returns the matcher sets for allow-listed origins: one for exact origins
+// and one regexp for wildcard origins, each combined with base. Any origin only requires
+// the Origin header to be present.
+func (p CORSPolicy) originMatches(base Match, name string) []Match {
+	withOrigin := func(m Match) Match {
+		m.Host = base.Host
+		m.Method = base.Method
+		m.Header = make(map[string][]string)
+		for k, v := range base.Header {
+			m.Header[k] = v
+		}
+		return m
+	}
+
+	if p.anyOrigin() {
+		m := withOrigin(Match{})
+		m.Header["Origin"] = []string{}
+		return []Match{m}
+	}
+
+	var exact, wildcards []string
+	for _, origin := range p.AllowedOrigins {
+		if strings.Contains(origin, "*") {
+			pattern := regexp.QuoteMeta(strings.ToLower(origin))
+			wildcards = append(wildcards, strings.Replace(pattern, `\*`, `[a-z0-9-]+`, 1))
+		} else {
+			exact = append(exact, origin)
+		}
+	}
+
+	var matches []Match
+	if len(exact) > 0 {
+		m := withOrigin(Match{})
+		m.Header["Origin"] = exact
+		matches = append(matches, m)
+	}
+	if len(wildcards) > 0 {
+		m := withOrigin(Match{})
+		m.HeaderRegexp = map[string]*RegexpMatch{
+			"Origin": {Name: name, Pattern: "(?i)^(" + strings.Join(wildcards, "|") + ")$"},
+		}
+		matches = append(matches, m)
+	}
+	return matches
+}
+
+// buildCORSRoutes creates the routes applying a CORS policy. Requests from allow-listed
+// origins get the origin reflected back (or "*" when any origin is allowed); preflight
+// OPTIONS requests from them are answered with 204 and never reach the handlers below.
+// Other origins get no CORS headers, so browsers block them.
+func buildCORSRoutes(policy CORSPolicy, base Match, id string) []Route {
+	allowOrigin := "{http.request.header.Origin}"
+	if policy.anyOrigin() {
+		allowOrigin = "*"
+	}
+
+	// Headers set after the handler writes the response, so upstream CORS headers are replaced
+	responseHeaders := map[string][]string{
+		"Access-Control-Allow-Origin": {allowOrigin},
+	}
+	if policy.AllowCredentials {
+		responseHeaders["Access-Control-Allow-Credentials"] = []string{"true"}
+	}
+	if len(policy.ExposedHeaders) > 0 {
+		responseHeaders["Access-Control-Expose-Headers"] = []string{strings.Join(policy.ExposedHeaders, ", ")}
+	}
+
+	var routes []Route
+
+	// Responses differ by Origin once it is reflected, so caches must key on it
+	if !policy.anyOrigin() {
+		routes = append(routes, Route{
+			ID:     prefixedID("cors_vary", id),
+			Match:  nonEmptyMatch(base),
+			Handle: []Handler{{Handler: "headers", Response: &HeaderOps{Add: map[string][]string{"Vary": {"Origin"}}, Deferred: true}}},
+		})
+	}
+
+	routes = append(routes, Route{
+		ID:     prefixedID("cors", id),
+		Match:  policy.originMatches(base, regexpMatcherName("cors", id)),
+		Handle: []Handler{{Handler: "headers", Response: &HeaderOps{Set: responseHeaders, Deferred: true}}},
+	})
+
+	methods := policy.AllowedMethods
+	if len(methods) == 0 {
+		methods = defaultCORSMethods
+	}
+	headers := policy.AllowedHeaders
+	if len(headers) == 0 {
+		headers = defaultCORSHeaders
+	}
+	preflightHeaders := map[string][]string{
+		"Access-Control-Allow-Methods": {strings.Join(methods, ", ")},
+		"Access-Control-Allow-Headers": {strings.Join(headers, ", ")},
+	}
+	if policy.MaxAge > 0 {
+		preflightHeaders["Access-Control-Max-Age"] = []string{strconv.Itoa(policy.MaxAge)}
+	}
+
+	preflightBase := base
+	preflightBase.Method = []string{"OPTIONS"}
+	preflightBase.Header = map[string][]string{"Access-Control-Request-Method": {}}
+	routes = append(routes, Route{
+		ID:    prefixedID("cors_preflight", id),
+		Match: policy.originMatches(preflightBase, regexpMatcherName("cors_preflight", id)),
+		Handle: []Handler{
+			{Handler: "headers", Response: &HeaderOps{Set: preflightHeaders}},
+			{Handler: "static_response", StatusCode: 204},
+		},
+		Terminal: true,
+	})
+
+	return routes
+}
+
+// prefixedID returns prefix_id, or "" for routes nested in a route that carries the ID
+func prefixedID(prefix, id string) string {
+	if id == "" {
+		return ""
+	}
+	return fmt.Sprintf("%s_%s", prefix, id)
+}
+
+// nonEmptyMatch wraps a matcher set, or returns nil when it is empty so the route matches everything
+func nonEmptyMatch(m Match) []Match {
+	if len(m.Host) == 0 && len(m.Path) == 0 && len(m.Method) == 0 && len(m.Header) == 0 {
+		return nil
+	}
+	return []Match{m}
+}
@@ -0,0 +1,143 @@
+package caddy
+
+import (
+	"caddyadmin/models"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+)
+
+// HTTPErrorConfig represents a server's error handling routes, run when a handler returns an error
+type HTTPErrorConfig struct {
+	Routes []Route `json:"routes,omitempty"`
+}
+
+// statusRange is an inclusive range of HTTP status codes
+type statusRange struct {
+	Min, Max int
+}
+
+// parseStatusCodes parses a comma-separated list of status codes ("404"), classes ("5xx")
+// and ranges ("500-599"). "*" matches every error and yields no ranges.
+func parseStatusCodes(spec string) ([]statusRange, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return nil, fmt.Errorf("status codes are required")
+	}
+	if spec == "*" {
+		return nil, nil
+	}
+
+	var ranges []statusRange
+	for _, term := range strings.Split(spec, ",") {
+		term = strings.ToLower(strings.TrimSpace(term))
+
+		var r statusRange
+		switch {
+		case len(term) == 3 && strings.HasSuffix(term, "xx"):
+			class, err := strconv.Atoi(term[:1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid status class %q", term)
+			}
+			r = statusRange{class * 100, class*100 + 99}
+		case strings.Contains(term, "-"):
+			from, to, _ := strings.Cut(term, "-")
+			lo, err1 := strconv.Atoi(strings.TrimSpace(from))
+			hi, err2 := strconv.Atoi(strings.TrimSpace(to))
+			if err1 != nil || err2 != nil || lo > hi {
+				return nil, fmt.Errorf("invalid status range %q", term)
+			}
+			r = statusRange{lo, hi}
+		default:
+			code, err := strconv.Atoi(term)
+			if err != nil {
+				return nil, fmt.Errorf("invalid status code %q", term)
+			}
+			r = statusRange{code, code}
+		}
+
+		// Handlers only produce client and server errors
+		if r.Min < 400 || r.Max > 599 {
+			return nil, fmt.Errorf("status %q is outside 400-599", term)
+		}
+		ranges = append(ranges, r)
+	}
+	return ranges, nil
+}
+
+// statusExpression builds a CEL expression matching the error's status against the ranges
+func statusExpression(ranges []statusRange) string {
+	var terms []string
+	for _, r := range ranges {
+		if r.Min == r.Max {
+			terms = append(terms, fmt.Sprintf("{http.error.status_code} == %d", r.Min))
+		} else {
+			terms = append(terms, fmt.Sprintf("({http.error.status_code} >= %d && {http.error.status_code} <= %d)", r.Min, r.Max))
+		}
+	}
+	return strings.Join(terms, " || ")
+}
+
+// buildErrorRoutes creates a site's error routes, first match wins. Files are served
+// from the site's public directory with the original error status kept.
+func buildErrorRoutes(site *models.Site, pages []models.ErrorPage, warnings *buildWarnings) []Route {
+	var routes []Route
+	for _, page := range pages {
+		if !page.Enabled {
+			continue
+		}
+		if err := ValidateErrorPage(page); err != nil {
+			warnings.add("site %s: skipped error page %s: %v", site.Name, page.ID, err)
+			continue
+		}
+
+		route := Route{
+			ID:       fmt.Sprintf("error_%s", page.ID),
+			Terminal: true,
+		}
+		ranges, _ := parseStatusCodes(page.StatusCodes)
+		if len(ranges) > 0 {
+			route.Match = []Match{{Expression: statusExpression(ranges)}}
+		}
+
+		switch page.Action {
+		case "static":
+			contentType := page.ContentType
+			if contentType == "" {
+				contentType = "text/html; charset=utf-8"
+			}
+			route.Handle = []Handler{{
+				Handler:    "static_response",
+				Headers:    map[string][]string{"Content-Type": {contentType}},
+				Body:       page.Body,
+				StatusCode: "{http.error.status_code}",
+			}}
+
+		case "redirect":
+			code := page.RedirectCode
+			if code == 0 {
+				code = 302
+			}
+			route.Handle = []Handler{{
+				Handler:    "static_response",
+				Headers:    map[string][]string{"Location": {page.Location}},
+				StatusCode: code,
+			}}
+
+		default:
+			root := SiteRoot(site.ID)
+			if root == "" {
+				warnings.add("site %s: skipped error page %s: no sites directory configured", site.Name, page.ID)
+				continue
+			}
+			route.Handle = []Handler{
+				{Handler: "rewrite", URI: path.Clean("/" + page.FilePath)},
+				{Handler: "file_server", Root: root, StatusCode: "{http.error.status_code}"},
+			}
+		}
+
+		routes = append(routes, route)
+	}
+	return routes
+}
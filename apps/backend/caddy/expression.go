@@ -0,0 +1,142 @@
+package caddy
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+)
+
+// placeholderShorthands maps Caddyfile shorthands, which are not expanded in JSON
+// expressions, to their full placeholder for a helpful error
+var placeholderShorthands = map[string]string{
+	"host":        "http.request.host",
+	"hostport":    "http.request.hostport",
+	"method":      "http.request.method",
+	"path":        "http.request.uri.path",
+	"query":       "http.request.uri.query",
+	"uri":         "http.request.uri",
+	"scheme":      "http.request.scheme",
+	"remote_host": "http.request.remote.host",
+	"remote_port": "http.request.remote.port",
+	"client_ip":   "http.vars.client_ip",
+}
+
+// exprOperators are the CEL operators built from the characters =!<>&|
+var exprOperators = map[string]bool{"==": true, "!=": true, "<": true, "<=": true, ">": true, ">=": true, "&&": true, "||": true}
+
+// checkExpressionSyntax performs the lexical checks of a CEL expression: balanced quotes
+// and brackets, operators CEL knows, and Caddyfile shorthand placeholders, which JSON
+// expressions do not expand. Functions, methods and types are left to Caddy, whose
+// CEL environment decides what exists.
+func checkExpressionSyntax(expr string) error {
+	var stack []rune
+	pairs := map[rune]rune{')': '(', ']': '[', '}': '{'}
+	runes := []rune(expr)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case r == '{' && isPlaceholderAt(runes, i):
+			end := i + 1
+			for runes[end] != '}' {
+				end++
+			}
+			name := string(runes[i+1 : end])
+			if err := checkPlaceholderShorthand(name); err != nil {
+				return err
+			}
+			i = end + 1
+
+		case r == '"' || r == '\'':
+			end, err := skipStringLiteral(runes, i)
+			if err != nil {
+				return err
+			}
+			i = end
+
+		case strings.ContainsRune("=<>&|", r) || (r == '!' && i+1 < len(runes) && runes[i+1] == '='):
+			start := i
+			i++
+			for i < len(runes) && strings.ContainsRune("=<>&|", runes[i]) {
+				i++
+			}
+			if op := string(runes[start:i]); !exprOperators[op] {
+				return fmt.Errorf("unknown operator %q at offset %d", op, start)
+			}
+
+		case r == '(' || r == '[' || r == '{':
+			stack = append(stack, r)
+			i++
+
+		case r == ')' || r == ']' || r == '}':
+			if len(stack) == 0 || stack[len(stack)-1] != pairs[r] {
+				return fmt.Errorf("unbalanced %q at offset %d", r, i)
+			}
+			stack = stack[:len(stack)-1]
+			i++
+
+		default:
+			i++
+		}
+	}
+
+	if len(stack) > 0 {
+		return fmt.Errorf("unclosed %q", stack[len(stack)-1])
+	}
+	return nil
+}
+
+// skipStringLiteral returns the offset after the string literal opening at i,
+// which may be triple-quoted
+func skipStringLiteral(runes []rune, i int) (int, error) {
+	start := i
+	quote := string(runes[i])
+	if i+2 < len(runes) && runes[i+1] == runes[i] && runes[i+2] == runes[i] {
+		quote = strings.Repeat(quote, 3)
+	}
+	i += len(quote)
+	for i < len(runes) {
+		switch {
+		case runes[i] == '\\':
+			i += 2
+			continue
+		case strings.HasPrefix(string(runes[i:]), quote):
+			return i + len(quote), nil
+		case runes[i] == '\n' && len(quote) == 1:
+			return 0, fmt.Errorf("unterminated string literal at offset %d", start)
+		}
+		i++
+	}
+	return 0, fmt.Errorf("unterminated string literal at offset %d", start)
+}
+
+// isPlaceholderAt reports whether a {name} placeholder starts at i, as opposed to a map literal
+func isPlaceholderAt(runes []rune, i int) bool {
+	if i+1 >= len(runes) || !unicode.IsLetter(runes[i+1]) {
+		return false
+	}
+	for j := i + 2; j < len(runes); j++ {
+		switch r := runes[j]; {
+		case r == '}':
+			return true
+		case r == '_' || r == '.' || r == '-' || unicode.IsLetter(r) || unicode.IsDigit(r):
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// checkPlaceholderShorthand rejects Caddyfile shorthands, which would silently
+// evaluate to an empty value in a JSON expression
+func checkPlaceholderShorthand(name string) error {
+	if full, ok := placeholderShorthands[name]; ok {
+		return fmt.Errorf("unknown placeholder {%s}: use {%s}", name, full)
+	}
+	for _, prefix := range []string{"header.", "query.", "cookie."} {
+		if rest, ok := strings.CutPrefix(name, prefix); ok {
+			return fmt.Errorf("unknown placeholder {%s}: use {http.request.%s%s}", name, prefix, rest)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,48 @@
+package caddy
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckExpressionSyntax(t *testing.T) {
+	tests := []struct {
+		expr    string
+		wantErr string // substring of the error, empty when the expression passes
+	}{
+		{expr: `{http.request.uri.path}.startsWith("/api") && method("POST")`},
+		{expr: `{http.error.status_code} == 404 || ({http.error.status_code} >= 500 && {http.error.status_code} <= 599)`},
+		{expr: `header({'X-Debug': '1'}) || !path('/admin/*')`},
+		{expr: `{http.request.header.X-Env} in ["staging", "dev"] ? true : false`},
+		{expr: `[1, 2, 3].exists(x, x > 2) && {time.now}.getHours() < 6`},
+		{expr: `{http.request.remote.host}.matches(r'^10\.') && size({env.APP_ROLE}) > 0`},
+		// Functions, methods and placeholders are Caddy's to resolve
+		{expr: `local_ip('10.0.0.0/8')`},
+		{expr: `{http.request.host}.reverse() == 'moc.elpmaxe'`},
+		{expr: `'a'.format([]) == "a"`},
+		{expr: `{tls.client.subject} != "" && {custom.module.value} == "x"`},
+		{expr: `'''multi "quoted" ''' == "a"`},
+		{expr: `{http.request.header.X} === 1`, wantErr: `unknown operator "==="`},
+		{expr: `{http.request.host} = "example.com"`, wantErr: `unknown operator "="`},
+		{expr: `path('/a'`, wantErr: `unclosed '('`},
+		{expr: `path('/a'))`, wantErr: `unbalanced ')'`},
+		{expr: `[1, 2)`, wantErr: `unbalanced ')'`},
+		{expr: `{path} == "/"`, wantErr: "use {http.request.uri.path}"},
+		{expr: `{header.Cookie}.contains("a")`, wantErr: "use {http.request.header.Cookie}"},
+		{expr: `"unterminated`, wantErr: "unterminated string literal"},
+		{expr: `path(")") && path('(`, wantErr: "unterminated string literal"},
+	}
+
+	for _, tt := range tests {
+		err := checkExpressionSyntax(tt.expr)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("checkExpressionSyntax(%s) error = %v, want it to pass", tt.expr, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("checkExpressionSyntax(%s) error = %v, want %q", tt.expr, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package caddy
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// sitesPath is the directory holding each site's managed files, set once at startup
+var sitesPath string
+
+// SetSitesPath sets the directory under which sites keep their files, as <path>/<site id>/public.
+// File servers without an explicit root serve from there.
+func SetSitesPath(path string) {
+	sitesPath = path
+}
+
+// SiteRoot returns the managed public directory of a site, or "" when no sites path is set
+func SiteRoot(siteID string) string {
+	if sitesPath == "" || siteID == "" {
+		return ""
+	}
+	return filepath.Join(sitesPath, siteID, "public")
+}
+
+// precompressedEncodings maps accepted sidecar names to Caddy's precompressed modules
+var precompressedEncodings = map[string]string{
+	"gzip": "gzip", "gz": "gzip",
+	"zstd": "zstd", "zst": "zstd",
+	"br": "br",
+}
+
+// fileServerConfig is the parsed step config of a file_server handler
+type fileServerConfig struct {
+	Root          string
+	Browse        bool
+	IndexNames    []string
+	Hide          []string
+	Precompressed []string // Caddy module names in preference order
+	PassThru      bool
+	TryFiles      []string
+	CacheControl  map[string]string // file extension without dot -> Cache-Control value
+}
+
+// parseFileServerConfig reads a file_server step config. SPA mode is shorthand for
+// try_files {path} {path}/ /index.html, with spa_fallback replacing /index.html.
+func parseFileServerConfig(config map[string]interface{}) (fileServerConfig, error) {
+	var fs fileServerConfig
+
+	fs.Root, _ = config["root"].(string)
+	fs.Browse, _ = config["browse"].(bool)
+	fs.PassThru, _ = config["pass_thru"].(bool)
+	fs.IndexNames = stringList(config["index"])
+	fs.Hide = stringList(config["hide"])
+
+	seen := make(map[string]bool)
+	for _, name := range stringList(config["precompressed"]) {
+		module, ok := precompressedEncodings[strings.ToLower(name)]
+		if !ok {
+			return fs, fmt.Errorf("unsupported precompressed encoding %q: must be gzip, zstd or br", name)
+		}
+		if !seen[module] {
+			seen[module] = true
+			fs.Precompressed = append(fs.Precompressed, module)
+		}
+	}
+
+	fs.TryFiles = stringList(config["try_files"])
+	if spa, _ := config["spa"].(bool); spa && len(fs.TryFiles) == 0 {
+		fallback, _ := config["spa_fallback"].(string)
+		if fallback == "" {
+			fallback = "/index.html"
+		}
+		if !strings.HasPrefix(fallback, "/") {
+			return fs, fmt.Errorf("spa_fallback must start with /")
+		}
+		fs.TryFiles = []string{"{http.request.uri.path}", "{http.request.uri.path}/", fallback}
+	}
+
+	if rules, ok := config["cache_control"].(map[string]interface{}); ok {
+		fs.CacheControl = make(map[string]string, len(rules))
+		for ext, v := range rules {
+			value, ok := v.(string)
+			if !ok || value == "" {
+				return fs, fmt.Errorf("cache_control for %s must be a non-empty string", ext)
+			}
+			ext = strings.TrimPrefix(strings.ToLower(ext), ".")
+			if ext == "" || strings.ContainsAny(ext, "/*") {
+				return fs, fmt.Errorf("invalid cache_control extension %q", ext)
+			}
+			fs.CacheControl[ext] = value
+		}
+	}
+
+	return fs, nil
+}
+
+// buildFileServer fills a file_server handler. Plain options stay on the handler;
+// try_files and Cache-Control rules need their own routes, so the handler is then
+// wrapped in a subroute: rewrite to the first existing file, set the headers, serve.
+func buildFileServer(handler *Handler, config map[string]interface{}, defaultRoot string) error {
+	fs, err := parseFileServerConfig(config)
+	if err != nil {
+		return err
+	}
+	if fs.Root == "" {
+		fs.Root = defaultRoot
+	}
+
+	fileServer := Handler{
+		Handler:    "file_server",
+		Root:       fs.Root,
+		IndexNames: fs.IndexNames,
+		Hide:       fs.Hide,
+		PassThru:   fs.PassThru,
+	}
+	if fs.Browse {
+		fileServer.Browse = struct{}{}
+	}
+	if len(fs.Precompressed) > 0 {
+		fileServer.Precompressed = make(map[string]interface{}, len(fs.Precompressed))
+		for _, module := range fs.Precompressed {
+			fileServer.Precompressed[module] = struct{}{}
+		}
+		fileServer.PrecompressedOrder = fs.Precompressed
+	}
+
+	if len(fs.TryFiles) == 0 && len(fs.CacheControl) == 0 {
+		*handler = fileServer
+		return nil
+	}
+
+	var routes []Route
+	if len(fs.TryFiles) > 0 {
+		routes = append(routes, Route{
+			Match: []Match{{
+				File: &FileMatch{Root: fs.Root, TryFiles: fs.TryFiles},
+			}},
+			Handle: []Handler{{
+				Handler: "rewrite",
+				URI:     "{http.matchers.file.relative}",
+			}},
+		})
+	}
+
+	// Sorted so the generated config is stable between syncs
+	exts := make([]string, 0, len(fs.CacheControl))
+	for ext := range fs.CacheControl {
+		exts = append(exts, ext)
+	}
+	sort.Strings(exts)
+	for _, ext := range exts {
+		routes = append(routes, Route{
+			Match: []Match{{Path: []string{"*." + ext}}},
+			Handle: []Handler{{
+				Handler: "headers",
+				Response: &HeaderOps{
+					Set:      map[string][]string{"Cache-Control": {fs.CacheControl[ext]}},
+					Deferred: true,
+				},
+			}},
+		})
+	}
+
+	routes = append(routes, Route{Handle: []Handler{fileServer}})
+
+	*handler = Handler{Handler: "subroute", Routes: routes}
+	return nil
+}
@@ -0,0 +1,244 @@
+package caddy
+
+import (
+	"caddyadmin/models"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// ProxyRewrite represents the rewrite applied to a proxied request's copy
+type ProxyRewrite struct {
+	Method string `json:"method,omitempty"`
+	URI    string `json:"uri,omitempty"`
+}
+
+// ForwardAuthConfig describes an identity proxy that authorizes each request before it is served
+type ForwardAuthConfig struct {
+	Provider    string   `json:"provider"`     // authelia, authentik, oauth2-proxy, custom
+	Upstream    string   `json:"upstream"`     // e.g. "authelia:9091" or "https://auth.internal:443"
+	URI         string   `json:"uri"`          // verification endpoint; defaults per provider
+	CopyHeaders []string `json:"copy_headers"` // identity headers copied into the request on success
+}
+
+// forwardAuthProviders holds the verification endpoint and identity headers of known identity proxies
+var forwardAuthProviders = map[string]ForwardAuthConfig{
+	"authelia": {
+		URI:         "/api/authz/forward-auth",
+		CopyHeaders: []string{"Remote-User", "Remote-Groups", "Remote-Email", "Remote-Name"},
+	},
+	"authentik": {
+		URI:         "/outpost.goauthentik.io/auth/caddy",
+		CopyHeaders: []string{"X-Authentik-Username", "X-Authentik-Groups", "X-Authentik-Email", "X-Authentik-Name", "X-Authentik-Uid"},
+	},
+	"oauth2-proxy": {
+		URI:         "/oauth2/auth",
+		CopyHeaders: []string{"X-Auth-Request-User", "X-Auth-Request-Email", "X-Auth-Request-Groups"},
+	},
+	"custom": {
+		CopyHeaders: []string{"Remote-User", "Remote-Groups"},
+	},
+}
+
+// withDefaults fills the URI and copied headers from the provider preset
+func (fa ForwardAuthConfig) withDefaults() ForwardAuthConfig {
+	if fa.Provider == "" {
+		fa.Provider = "custom"
+	}
+	preset := forwardAuthProviders[fa.Provider]
+	if fa.URI == "" {
+		fa.URI = preset.URI
+	}
+	if len(fa.CopyHeaders) == 0 {
+		fa.CopyHeaders = preset.CopyHeaders
+	}
+	return fa
+}
+
+// upstream splits the configured address into an upstream with its scheme
+func (fa ForwardAuthConfig) upstream() models.Upstream {
+	u := models.Upstream{Address: fa.Upstream, Scheme: "http", Enabled: true}
+	if scheme, rest, found := strings.Cut(fa.Upstream, "://"); found {
+		u.Scheme, u.Address = scheme, strings.TrimSuffix(rest, "/")
+	}
+	return u
+}
+
+// forwardAuthFromStep reads a forward_auth step config
+func forwardAuthFromStep(config map[string]interface{}) ForwardAuthConfig {
+	var fa ForwardAuthConfig
+	fa.Provider, _ = config["provider"].(string)
+	fa.Upstream, _ = config["upstream"].(string)
+	fa.URI, _ = config["uri"].(string)
+	fa.CopyHeaders = stringList(config["copy_headers"])
+	return fa
+}
+
+// forwardAuthFromSettings reads a site's forward auth settings
+func forwardAuthFromSettings(settings *models.MiddlewareSettings) ForwardAuthConfig {
+	fa := ForwardAuthConfig{
+		Provider: settings.ForwardAuthProvider,
+		Upstream: settings.ForwardAuthUpstream,
+		URI:      settings.ForwardAuthURI,
+	}
+	if settings.ForwardAuthCopyHeadersJSON != "" {
+		json.Unmarshal([]byte(settings.ForwardAuthCopyHeadersJSON), &fa.CopyHeaders)
+	}
+	return fa
+}
+
+// buildForwardAuthHandler expands forward auth the way Caddy's forward_auth directive does:
+// a GET to the verification URI carrying the original method and URI, where a 2xx response
+// copies the identity headers into the request and lets it continue, and anything else
+// (401, a 302 to the login page) is sent back to the client as is
+func buildForwardAuthHandler(fa ForwardAuthConfig) (Handler, error) {
+	fa = fa.withDefaults()
+	if err := ValidateForwardAuth(fa); err != nil {
+		return Handler{}, err
+	}
+
+	upstream := fa.upstream()
+	handler := Handler{
+		Handler:      "reverse_proxy",
+		Upstreams:    []Upstream{{Dial: upstream.Address}},
+		ProxyRewrite: &ProxyRewrite{Method: "GET", URI: fa.URI},
+		Headers: &Headers{Request: &HeaderOps{Set: map[string][]string{
+			"X-Forwarded-Method": {"{http.request.method}"},
+			"X-Forwarded-Uri":    {"{http.request.uri}"},
+		}}},
+	}
+	if transport, _ := buildTransport(upstream, nil); transport != nil {
+		handler.Transport = transport
+	}
+
+	// Clients must not be able to send identity headers themselves, so they are
+	// removed first and only set when the auth service returned them
+	routes := []Route{{
+		Handle: []Handler{{Handler: "headers", Request: &HeaderOps{Delete: fa.CopyHeaders}}},
+	}}
+	for _, name := range fa.CopyHeaders {
+		placeholder := fmt.Sprintf("{http.reverse_proxy.header.%s}", name)
+		routes = append(routes, Route{
+			Match:  []Match{{Not: []Match{{Vars: map[string][]string{placeholder: {""}}}}}},
+			Handle: []Handler{{Handler: "headers", Request: &HeaderOps{Set: map[string][]string{name: {placeholder}}}}},
+		})
+	}
+	handler.HandleResponse = []HandleResponse{{
+		Match:  &ResponseMatch{StatusCode: []int{2}},
+		Routes: routes,
+	}}
+
+	return handler, nil
+}
+
+// buildForwardAuthRoute creates the non-terminal route that guards a whole site with forward auth
+func buildForwardAuthRoute(site *models.Site, settings *models.MiddlewareSettings) (*Route, error) {
+	handler, err := buildForwardAuthHandler(forwardAuthFromSettings(settings))
+	if err != nil {
+		return nil, err
+	}
+
+	match := Match{}
+	if len(site.Hosts) > 0 {
+		match.Host = site.Hosts
+	}
+	var exclude []string
+	if settings.ForwardAuthExcludeJSON != "" {
+		json.Unmarshal([]byte(settings.ForwardAuthExcludeJSON), &exclude)
+	}
+	if len(exclude) > 0 {
+		match.Not = []Match{{Path: exclude}}
+	}
+
+	return &Route{
+		ID:     fmt.Sprintf("forward_auth_%s", site.ID),
+		Match:  []Match{match},
+		Handle: []Handler{handler},
+	}, nil
+}
+
+// ForwardAuthCheck is the outcome of a verification request sent like Caddy would send it
+type ForwardAuthCheck struct {
+	URL           string            `json:"url"`
+	StatusCode    int               `json:"status_code"`
+	Authenticated bool              `json:"authenticated"`
+	Location      string            `json:"location,omitempty"`
+	Headers       map[string]string `json:"headers"` // identity headers that would be copied
+}
+
+// CheckForwardAuth sends one verification request to the auth service for the given
+// original request method, URL and headers (e.g. a session Cookie), without following
+// redirects. The method defaults to GET.
+func CheckForwardAuth(fa ForwardAuthConfig, method, requestURL string, headers map[string]string, insecureSkipVerify bool) (*ForwardAuthCheck, error) {
+	fa = fa.withDefaults()
+	if err := ValidateForwardAuth(fa); err != nil {
+		return nil, err
+	}
+
+	if method == "" {
+		method = http.MethodGet
+	}
+	method = strings.ToUpper(method)
+	if strings.ContainsAny(method, " ,\r\n") {
+		return nil, fmt.Errorf("invalid request method %q", method)
+	}
+
+	original, err := url.Parse(requestURL)
+	if err != nil || original.Host == "" {
+		return nil, fmt.Errorf("invalid request URL %q", requestURL)
+	}
+
+	upstream := fa.upstream()
+	scheme := "http"
+	if upstream.Scheme == "https" {
+		scheme = "https"
+	}
+	checkURL := scheme + "://" + upstream.Address + fa.URI
+
+	req, err := http.NewRequest(http.MethodGet, checkURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("X-Forwarded-Method", method)
+	req.Header.Set("X-Forwarded-Uri", original.RequestURI())
+	req.Header.Set("X-Forwarded-Host", original.Host)
+	req.Header.Set("X-Forwarded-Proto", original.Scheme)
+
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: insecureSkipVerify},
+		},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("auth service unreachable: %w", err)
+	}
+	defer resp.Body.Close()
+
+	check := &ForwardAuthCheck{
+		URL:           checkURL,
+		StatusCode:    resp.StatusCode,
+		Authenticated: resp.StatusCode >= 200 && resp.StatusCode < 300,
+		Location:      resp.Header.Get("Location"),
+		Headers:       map[string]string{},
+	}
+	if check.Authenticated {
+		for _, name := range fa.CopyHeaders {
+			if value := resp.Header.Get(name); value != "" {
+				check.Headers[name] = value
+			}
+		}
+	}
+	return check, nil
+}
@@ -0,0 +1,189 @@
+package caddy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuildForwardAuthHandler(t *testing.T) {
+	handler, err := buildForwardAuthHandler(ForwardAuthConfig{
+		Provider:    "authelia",
+		Upstream:    "https://authelia.internal:9091",
+		CopyHeaders: []string{"Remote-User", "Remote-Groups"},
+	})
+	if err != nil {
+		t.Fatalf("buildForwardAuthHandler() error = %v", err)
+	}
+
+	if handler.Handler != "reverse_proxy" {
+		t.Errorf("handler = %q, want reverse_proxy", handler.Handler)
+	}
+	if len(handler.Upstreams) != 1 || handler.Upstreams[0].Dial != "authelia.internal:9091" {
+		t.Errorf("upstreams = %+v, want authelia.internal:9091", handler.Upstreams)
+	}
+	if handler.Transport == nil {
+		t.Error("transport = nil, want TLS for an https upstream")
+	}
+
+	// The verification request is a GET to the provider endpoint carrying the original request
+	want := &ProxyRewrite{Method: "GET", URI: "/api/authz/forward-auth"}
+	if !reflect.DeepEqual(handler.ProxyRewrite, want) {
+		t.Errorf("rewrite = %+v, want %+v", handler.ProxyRewrite, want)
+	}
+	headers, ok := handler.Headers.(*Headers)
+	if !ok || headers.Request == nil {
+		t.Fatalf("headers = %#v, want request header operations", handler.Headers)
+	}
+	set := headers.Request.Set
+	if got := set["X-Forwarded-Method"]; !reflect.DeepEqual(got, []string{"{http.request.method}"}) {
+		t.Errorf("X-Forwarded-Method = %v, want the original method", got)
+	}
+	if got := set["X-Forwarded-Uri"]; !reflect.DeepEqual(got, []string{"{http.request.uri}"}) {
+		t.Errorf("X-Forwarded-Uri = %v, want the original URI", got)
+	}
+
+	// Only 2xx responses are handled; 401s and login redirects go back to the client untouched
+	if len(handler.HandleResponse) != 1 {
+		t.Fatalf("handle_response = %+v, want a single 2xx handler", handler.HandleResponse)
+	}
+	hr := handler.HandleResponse[0]
+	if hr.Match == nil || !reflect.DeepEqual(hr.Match.StatusCode, []int{2}) {
+		t.Errorf("handle_response match = %+v, want status class 2", hr.Match)
+	}
+	if hr.StatusCode != nil {
+		t.Errorf("handle_response status_code = %v, want the upstream status kept", hr.StatusCode)
+	}
+
+	// Client-sent identity headers are dropped, then copied from the auth response when present
+	if len(hr.Routes) != 3 {
+		t.Fatalf("handle_response routes = %d, want a delete route and one per header", len(hr.Routes))
+	}
+	if got := hr.Routes[0].Handle[0].Request.Delete; !reflect.DeepEqual(got, []string{"Remote-User", "Remote-Groups"}) {
+		t.Errorf("deleted headers = %v, want the copied headers", got)
+	}
+	for i, name := range []string{"Remote-User", "Remote-Groups"} {
+		route := hr.Routes[i+1]
+		placeholder := "{http.reverse_proxy.header." + name + "}"
+		if got := route.Handle[0].Request.Set[name]; !reflect.DeepEqual(got, []string{placeholder}) {
+			t.Errorf("%s = %v, want %s", name, got, placeholder)
+		}
+		if len(route.Match) != 1 || len(route.Match[0].Not) != 1 || route.Match[0].Not[0].Vars[placeholder] == nil {
+			t.Errorf("%s route match = %+v, want it skipped when the auth response lacks the header", name, route.Match)
+		}
+	}
+}
+
+func TestBuildForwardAuthHandlerInvalid(t *testing.T) {
+	if _, err := buildForwardAuthHandler(ForwardAuthConfig{Provider: "custom", Upstream: "auth:9000"}); err == nil {
+		t.Error("buildForwardAuthHandler() accepted a custom provider without a URI")
+	}
+}
+
+func TestCheckForwardAuth(t *testing.T) {
+	var forwarded http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Clone()
+		if r.Method != http.MethodGet || r.URL.Path != "/api/authz/forward-auth" {
+			http.Error(w, "unexpected verification request", http.StatusBadRequest)
+			return
+		}
+		switch r.Header.Get("Cookie") {
+		case "session=valid":
+			w.Header().Set("Remote-User", "alice")
+			w.Header().Set("Remote-Groups", "admins,dev")
+			w.Header().Set("X-Internal", "not copied")
+			w.WriteHeader(http.StatusOK)
+		case "session=expired":
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.Header().Set("Location", "https://auth.example.com/?rd=https%3A%2F%2Fapp.example.com%2Fprivate")
+			w.WriteHeader(http.StatusFound)
+		}
+	}))
+	defer server.Close()
+
+	fa := ForwardAuthConfig{Provider: "authelia", Upstream: server.URL}
+
+	tests := []struct {
+		name          string
+		method        string
+		cookie        string
+		status        int
+		authenticated bool
+		location      string
+		headers       map[string]string
+		wantMethod    string
+	}{
+		{
+			name:          "authenticated",
+			cookie:        "session=valid",
+			status:        http.StatusOK,
+			authenticated: true,
+			headers:       map[string]string{"Remote-User": "alice", "Remote-Groups": "admins,dev"},
+			wantMethod:    "GET",
+		},
+		{
+			name:       "unauthorized",
+			method:     "post",
+			cookie:     "session=expired",
+			status:     http.StatusUnauthorized,
+			headers:    map[string]string{},
+			wantMethod: "POST",
+		},
+		{
+			name:       "login redirect",
+			method:     "DELETE",
+			status:     http.StatusFound,
+			location:   "https://auth.example.com/?rd=https%3A%2F%2Fapp.example.com%2Fprivate",
+			headers:    map[string]string{},
+			wantMethod: "DELETE",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			headers := map[string]string{}
+			if tt.cookie != "" {
+				headers["Cookie"] = tt.cookie
+			}
+			check, err := CheckForwardAuth(fa, tt.method, "https://app.example.com/private?page=2", headers, false)
+			if err != nil {
+				t.Fatalf("CheckForwardAuth() error = %v", err)
+			}
+
+			if check.URL != server.URL+"/api/authz/forward-auth" {
+				t.Errorf("url = %q, want the provider endpoint", check.URL)
+			}
+			if check.StatusCode != tt.status || check.Authenticated != tt.authenticated {
+				t.Errorf("status = %d, authenticated = %v, want %d, %v", check.StatusCode, check.Authenticated, tt.status, tt.authenticated)
+			}
+			if check.Location != tt.location {
+				t.Errorf("location = %q, want %q", check.Location, tt.location)
+			}
+			if !reflect.DeepEqual(check.Headers, tt.headers) {
+				t.Errorf("headers = %v, want %v", check.Headers, tt.headers)
+			}
+
+			for name, want := range map[string]string{
+				"X-Forwarded-Method": tt.wantMethod,
+				"X-Forwarded-Uri":    "/private?page=2",
+				"X-Forwarded-Host":   "app.example.com",
+				"X-Forwarded-Proto":  "https",
+			} {
+				if got := forwarded.Get(name); got != want {
+					t.Errorf("%s = %q, want %q", name, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckForwardAuthInvalidMethod(t *testing.T) {
+	fa := ForwardAuthConfig{Provider: "authelia", Upstream: "authelia:9091"}
+	if _, err := CheckForwardAuth(fa, "GET POST", "https://app.example.com/", nil, false); err == nil || !strings.Contains(err.Error(), "method") {
+		t.Errorf("CheckForwardAuth() error = %v, want invalid method", err)
+	}
+}
@@ -0,0 +1,86 @@
+package caddy
+
+import (
+	"caddyadmin/models"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// MaintenanceBypassCookie is the cookie whose value must match a site's bypass secret
+const MaintenanceBypassCookie = "caddyadmin_maintenance_bypass"
+
+// maintenanceSecretPattern keeps bypass secrets long enough to resist guessing and safe in a cookie
+var maintenanceSecretPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{16,128}$`)
+
+// defaultMaintenancePage is served when a site has no custom maintenance page
+const defaultMaintenancePage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Down for maintenance</title>
+<style>body{font-family:system-ui,sans-serif;display:flex;align-items:center;justify-content:center;min-height:100vh;margin:0;color:#333;background:#f6f7f9}main{text-align:center;padding:2rem}</style>
+</head>
+<body>
+<main>
+<h1>Down for maintenance</h1>
+<p>We are performing scheduled maintenance and will be back shortly.</p>
+</main>
+</body>
+</html>
+`
+
+// buildMaintenanceRoute creates the terminal 503 route of a site in maintenance mode.
+// Clients in the bypass list and requests carrying the bypass cookie fall through to
+// the site's routes. Retry-After points at the end of the running window when there
+// is one, otherwise it carries the site's configured delay in seconds.
+func buildMaintenanceRoute(site *models.Site, until *time.Time) Route {
+	match := Match{}
+	if len(site.Hosts) > 0 {
+		match.Host = site.Hosts
+	}
+
+	var bypassIPs []string
+	if site.MaintenanceBypassIPsJSON != "" {
+		json.Unmarshal([]byte(site.MaintenanceBypassIPsJSON), &bypassIPs)
+	}
+	if len(bypassIPs) > 0 {
+		match.Not = append(match.Not, Match{ClientIP: &IPRangeMatch{Ranges: bypassIPs}})
+	}
+	if site.MaintenanceBypassSecret != "" {
+		match.Not = append(match.Not, Match{HeaderRegexp: map[string]*RegexpMatch{
+			"Cookie": {Pattern: `(^|;\s*)` + MaintenanceBypassCookie + `=` + regexp.QuoteMeta(site.MaintenanceBypassSecret) + `(;|$)`},
+		}})
+	}
+
+	headers := map[string][]string{
+		"Content-Type":  {"text/html; charset=utf-8"},
+		"Cache-Control": {"no-store"},
+	}
+	if until != nil && until.After(time.Now()) {
+		headers["Retry-After"] = []string{until.UTC().Format(http.TimeFormat)}
+	} else if site.MaintenanceRetryAfter > 0 {
+		headers["Retry-After"] = []string{strconv.Itoa(site.MaintenanceRetryAfter)}
+	}
+
+	body := site.MaintenancePage
+	if body == "" {
+		body = defaultMaintenancePage
+	}
+
+	return Route{
+		ID:    fmt.Sprintf("maintenance_%s", site.ID),
+		Match: []Match{match},
+		Handle: []Handler{{
+			Handler:    "static_response",
+			StatusCode: http.StatusServiceUnavailable,
+			Headers:    headers,
+			Body:       body,
+		}},
+		Terminal: true,
+	}
+}
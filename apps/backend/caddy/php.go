@@ -0,0 +1,208 @@
+package caddy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// FileMatch represents a file matcher, which matches when one of the files exists under root
+type FileMatch struct {
+	Root      string   `json:"root,omitempty"`
+	TryFiles  []string `json:"try_files,omitempty"`
+	TryPolicy string   `json:"try_policy,omitempty"`
+	SplitPath []string `json:"split_path,omitempty"`
+}
+
+// phpFastCGIConfig is the parsed step config of a php_fastcgi handler
+type phpFastCGIConfig struct {
+	Root          string
+	Dials         []string
+	SplitPath     []string
+	Index         []string
+	TryFiles      []string
+	Env           map[string]string
+	CanonicalURIs bool
+	FileServer    bool
+}
+
+// parsePHPFastCGIConfig reads a php_fastcgi step config, applying the defaults of
+// Caddy's php_fastcgi directive: split on .php, index.php as index, canonical URIs on
+func parsePHPFastCGIConfig(config map[string]interface{}) (phpFastCGIConfig, error) {
+	php := phpFastCGIConfig{
+		SplitPath:     []string{".php"},
+		Index:         []string{"index.php"},
+		CanonicalURIs: true,
+		FileServer:    true,
+	}
+
+	php.Root, _ = config["root"].(string)
+
+	// A single dial or a list of them; php-fpm pools are usually one socket
+	if dial, ok := config["dial"].(string); ok && dial != "" {
+		php.Dials = append(php.Dials, dial)
+	}
+	php.Dials = append(php.Dials, stringList(config["upstreams"])...)
+	if len(php.Dials) == 0 {
+		return php, fmt.Errorf("dial is required, e.g. unix//run/php/php-fpm.sock or 127.0.0.1:9000")
+	}
+	for i, dial := range php.Dials {
+		dial = fastCGIDial(dial)
+		if strings.Contains(dial, "://") {
+			return php, fmt.Errorf("invalid dial %q: use unix//path/to.sock or host:port", php.Dials[i])
+		}
+		if !strings.HasPrefix(dial, "unix/") && !strings.Contains(dial, ":") {
+			return php, fmt.Errorf("invalid dial %q: TCP addresses need a port", php.Dials[i])
+		}
+		php.Dials[i] = dial
+	}
+
+	if _, ok := config["split_path"]; ok {
+		php.SplitPath = stringList(config["split_path"])
+		if len(php.SplitPath) == 0 {
+			return php, fmt.Errorf("split_path cannot be empty")
+		}
+	}
+	if _, ok := config["index"]; ok {
+		php.Index = stringList(config["index"])
+		if len(php.Index) == 0 {
+			return php, fmt.Errorf("index cannot be empty")
+		}
+	}
+	php.TryFiles = stringList(config["try_files"])
+
+	if env, ok := config["env"].(map[string]interface{}); ok {
+		php.Env = make(map[string]string, len(env))
+		for k, v := range env {
+			s, ok := v.(string)
+			if !ok {
+				return php, fmt.Errorf("env %s must be a string", k)
+			}
+			php.Env[k] = s
+		}
+	}
+
+	if canonical, ok := config["canonical_uris"].(bool); ok {
+		php.CanonicalURIs = canonical
+	}
+	if fileServer, ok := config["file_server"].(bool); ok {
+		php.FileServer = fileServer
+	}
+
+	return php, nil
+}
+
+// fastCGIDial normalizes a php-fpm address; a bare socket path becomes a unix dial
+func fastCGIDial(dial string) string {
+	dial = strings.TrimSpace(dial)
+	if strings.HasPrefix(dial, "unix:") {
+		return "unix/" + strings.TrimPrefix(strings.TrimPrefix(dial, "unix:"), "//")
+	}
+	if strings.HasPrefix(dial, "/") {
+		return "unix/" + dial
+	}
+	return dial
+}
+
+// buildPHPFastCGIRoutes expands a php_fastcgi step the way the Caddyfile directive
+// does: redirect directories to their canonical trailing-slash path, rewrite to the
+// matched file or the front controller, proxy .php files over FastCGI and serve
+// everything else from disk
+func buildPHPFastCGIRoutes(config map[string]interface{}, defaultRoot string) ([]Route, error) {
+	php, err := parsePHPFastCGIConfig(config)
+	if err != nil {
+		return nil, err
+	}
+	if php.Root == "" {
+		php.Root = defaultRoot
+	}
+	if php.Root == "" {
+		return nil, fmt.Errorf("root is required")
+	}
+
+	var routes []Route
+
+	// 1. /dir -> /dir/ when /dir/index.php exists, so relative links resolve
+	if php.CanonicalURIs {
+		var indexFiles []string
+		for _, index := range php.Index {
+			indexFiles = append(indexFiles, "{http.request.uri.path}/"+index)
+		}
+		routes = append(routes, Route{
+			Match: []Match{{
+				File: &FileMatch{Root: php.Root, TryFiles: indexFiles},
+				Not:  []Match{{Path: []string{"*/"}}},
+			}},
+			Handle: []Handler{{
+				Handler:    "static_response",
+				Headers:    map[string][]string{"Location": {"{http.request.orig_uri.path}/{http.request.orig_uri.prefixed_query}"}},
+				StatusCode: 308,
+			}},
+		})
+	}
+
+	// 2. Rewrite to the first existing file, directory index or front controller
+	tryFiles := php.TryFiles
+	if len(tryFiles) == 0 {
+		tryFiles = []string{"{http.request.uri.path}"}
+		for _, index := range php.Index {
+			tryFiles = append(tryFiles, "{http.request.uri.path}/"+index)
+		}
+		tryFiles = append(tryFiles, php.Index[0])
+	}
+	routes = append(routes, Route{
+		Match: []Match{{
+			File: &FileMatch{Root: php.Root, TryFiles: tryFiles, SplitPath: php.SplitPath},
+		}},
+		Handle: []Handler{{
+			Handler: "rewrite",
+			URI:     "{http.matchers.file.relative}",
+		}},
+	})
+
+	// 3. PHP scripts go to php-fpm
+	transport := map[string]interface{}{
+		"protocol":   "fastcgi",
+		"root":       php.Root,
+		"split_path": php.SplitPath,
+	}
+	if len(php.Env) > 0 {
+		transport["env"] = php.Env
+	}
+	proxy := Handler{Handler: "reverse_proxy", Transport: transport}
+	for _, dial := range php.Dials {
+		proxy.Upstreams = append(proxy.Upstreams, Upstream{Dial: dial})
+	}
+
+	var phpPaths []string
+	for _, split := range php.SplitPath {
+		phpPaths = append(phpPaths, "*"+split)
+	}
+	routes = append(routes, Route{
+		Match:  []Match{{Path: phpPaths}},
+		Handle: []Handler{proxy},
+	})
+
+	// 4. Static assets
+	if php.FileServer {
+		routes = append(routes, Route{
+			Handle: []Handler{{Handler: "file_server", Root: php.Root}},
+		})
+	}
+
+	return routes, nil
+}
+
+// stringList reads a JSON array of strings from a step config value
+func stringList(value interface{}) []string {
+	items, ok := value.([]interface{})
+	if !ok {
+		return nil
+	}
+	var list []string
+	for _, item := range items {
+		if s, ok := item.(string); ok && s != "" {
+			list = append(list, s)
+		}
+	}
+	return list
+}
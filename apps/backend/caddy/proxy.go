@@ -0,0 +1,627 @@
+package caddy
+
+import (
+	"caddyadmin/models"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// buildReverseProxy fills a reverse_proxy handler from its step config, resolving
+// upstream groups and generating the transport for the upstreams' scheme
+func buildReverseProxy(handler *Handler, config map[string]interface{}, upstreamGroups map[string]*models.UpstreamGroup, upstreams map[string][]models.Upstream, certificates map[string]models.CustomCertificate, warnings *buildWarnings) error {
+	var transport map[string]interface{}
+
+	// Get upstream group or direct upstreams
+	if groupName, ok := config["upstream_group"].(string); ok {
+		if group, exists := upstreamGroups[groupName]; exists {
+			var enabled []models.Upstream
+			for _, u := range upstreams[groupName] {
+				if u.Enabled {
+					enabled = append(enabled, u)
+					handler.Upstreams = append(handler.Upstreams, Upstream{
+						Dial:        u.Address,
+						MaxRequests: u.MaxRequests,
+					})
+				}
+			}
+
+			if err := ValidateUpstreamTransport(enabled); err != nil {
+				return fmt.Errorf("upstream group %s: %w", groupName, err)
+			}
+			if len(enabled) > 0 {
+				var err error
+				if transport, err = buildTransport(enabled[0], certificates); err != nil {
+					return fmt.Errorf("upstream group %s: %w", groupName, err)
+				}
+			}
+
+			handler.LoadBalancing = buildLoadBalancing(group, enabled)
+			handler.HealthChecks = buildHealthChecks(group, enabled, warnings)
+
+			// Caddy limits connections per host on the transport, which all upstreams
+			// share, so the strictest per-upstream limit applies to each of them
+			if maxConns := maxConnectionsPerHost(enabled); maxConns > 0 {
+				if transport == nil {
+					transport = map[string]interface{}{}
+				}
+				transport["max_conns_per_host"] = maxConns
+			}
+		}
+	} else if upstreamAddrs, ok := config["upstreams"].([]interface{}); ok {
+		// Direct upstreams may carry a scheme prefix, e.g. "https://api.internal:443"
+		var direct []models.Upstream
+		for _, addr := range upstreamAddrs {
+			if a, ok := addr.(string); ok {
+				u := models.Upstream{Address: a, Scheme: "http", Enabled: true}
+				if scheme, rest, found := strings.Cut(a, "://"); found {
+					u.Scheme, u.Address = scheme, rest
+				}
+				if err := ValidateUpstream(u); err != nil {
+					return fmt.Errorf("upstream %s: %w", a, err)
+				}
+				direct = append(direct, u)
+				handler.Upstreams = append(handler.Upstreams, Upstream{Dial: u.Address})
+			}
+		}
+
+		if err := ValidateUpstreamTransport(direct); err != nil {
+			return err
+		}
+		if len(direct) > 0 {
+			transport, _ = buildTransport(direct[0], nil)
+		}
+	}
+
+	// Structured proxy options; timeouts and keepalive belong to the http transport
+	opts, err := parseProxyOptions(config)
+	if err != nil {
+		return err
+	}
+	if opts.Headers != nil {
+		handler.Headers = opts.Headers
+	}
+	handler.FlushInterval = opts.FlushInterval
+	handler.RequestBuffers = opts.RequestBuffers
+	handler.ResponseBuffers = opts.ResponseBuffers
+	handler.HandleResponse = opts.HandleResponse
+	if len(opts.Transport) > 0 {
+		if transport == nil {
+			transport = map[string]interface{}{}
+		}
+		for k, v := range opts.Transport {
+			transport[k] = v
+		}
+	}
+
+	// Explicit transport configuration overrides the generated one key by key
+	if raw, ok := config["transport"].(map[string]interface{}); ok {
+		if transport == nil {
+			transport = map[string]interface{}{}
+		}
+		for k, v := range raw {
+			transport[k] = v
+		}
+	}
+	if transport != nil {
+		if _, ok := transport["protocol"]; !ok {
+			transport["protocol"] = "http"
+		}
+		handler.Transport = transport
+	}
+
+	return nil
+}
+
+// buildLoadBalancing creates the load_balancing config for a group: the selection
+// policy with its options, plus retry behaviour. Returns nil when nothing is set.
+func buildLoadBalancing(group *models.UpstreamGroup, upstreams []models.Upstream) map[string]interface{} {
+	loadBalancing := map[string]interface{}{}
+
+	if group.LoadBalancing != "" {
+		policy := map[string]interface{}{
+			"policy": group.LoadBalancing,
+		}
+		switch group.LoadBalancing {
+		case "weighted_round_robin":
+			// Weights are positional and must line up with the handler's upstreams
+			weights := make([]int, 0, len(upstreams))
+			for _, u := range upstreams {
+				weight := u.Weight
+				if weight < 1 {
+					weight = 1
+				}
+				weights = append(weights, weight)
+			}
+			policy["weights"] = weights
+		case "header":
+			policy["field"] = group.LoadBalancingHeader
+		case "cookie":
+			if group.LoadBalancingCookie != "" {
+				policy["name"] = group.LoadBalancingCookie
+			}
+			if group.LoadBalancingCookieSecret != "" {
+				policy["secret"] = group.LoadBalancingCookieSecret
+			}
+		case "query":
+			policy["key"] = group.LoadBalancingQueryKey
+		}
+		loadBalancing["selection_policy"] = policy
+	}
+
+	if group.Retries > 0 {
+		loadBalancing["retries"] = group.Retries
+	}
+	if group.TryDuration > 0 {
+		loadBalancing["try_duration"] = fmt.Sprintf("%ds", group.TryDuration)
+		if group.TryInterval > 0 {
+			loadBalancing["try_interval"] = fmt.Sprintf("%dms", group.TryInterval)
+		}
+	}
+
+	if len(loadBalancing) == 0 {
+		return nil
+	}
+	return loadBalancing
+}
+
+// maxConnectionsPerHost returns the lowest non-zero MaxConnections of the upstreams
+func maxConnectionsPerHost(upstreams []models.Upstream) int {
+	maxConns := 0
+	for _, u := range upstreams {
+		if u.MaxConnections > 0 && (maxConns == 0 || u.MaxConnections < maxConns) {
+			maxConns = u.MaxConnections
+		}
+	}
+	return maxConns
+}
+
+// buildHealthChecks creates the active and passive health check config for a group.
+// Returns nil when neither is enabled.
+func buildHealthChecks(group *models.UpstreamGroup, upstreams []models.Upstream, warnings *buildWarnings) map[string]interface{} {
+	healthChecks := map[string]interface{}{}
+
+	uri := activeHealthCheckURI(group, upstreams)
+	if group.HealthChecks && len(upstreams) > 0 && uri == "" {
+		// Probing one upstream's path on the others would mark healthy upstreams down
+		warnings.add("upstream group %s: upstreams use different health check paths (%s) and the group sets none; active health checks disabled",
+			group.Name, strings.Join(upstreamHealthCheckPaths(upstreams), ", "))
+	} else if group.HealthChecks && len(upstreams) > 0 {
+		active := map[string]interface{}{
+			"uri":      uri,
+			"interval": fmt.Sprintf("%ds", activeHealthCheckInterval(upstreams)),
+		}
+		if group.HealthCheckTimeout > 0 {
+			active["timeout"] = fmt.Sprintf("%ds", group.HealthCheckTimeout)
+		}
+		if group.HealthCheckExpectStatus > 0 {
+			active["expect_status"] = group.HealthCheckExpectStatus
+		}
+
+		var headers map[string]string
+		if group.HealthCheckHeadersJSON != "" {
+			json.Unmarshal([]byte(group.HealthCheckHeadersJSON), &headers)
+		}
+		if len(headers) > 0 {
+			activeHeaders := make(map[string][]string, len(headers))
+			for name, value := range headers {
+				activeHeaders[name] = []string{value}
+			}
+			active["headers"] = activeHeaders
+		}
+
+		healthChecks["active"] = active
+	}
+
+	// Caddy only enables passive checks when fail_duration is set
+	if group.PassiveHealth && group.PassiveFailDuration > 0 {
+		passive := map[string]interface{}{
+			"fail_duration": fmt.Sprintf("%ds", group.PassiveFailDuration),
+		}
+		if group.PassiveMaxFails > 0 {
+			passive["max_fails"] = group.PassiveMaxFails
+		}
+
+		var statuses []int
+		if group.PassiveUnhealthyStatusJSON != "" {
+			json.Unmarshal([]byte(group.PassiveUnhealthyStatusJSON), &statuses)
+		}
+		if len(statuses) > 0 {
+			passive["unhealthy_status"] = statuses
+		}
+		if group.PassiveUnhealthyLatency > 0 {
+			passive["unhealthy_latency"] = fmt.Sprintf("%dms", group.PassiveUnhealthyLatency)
+		}
+
+		healthChecks["passive"] = passive
+	}
+
+	if len(healthChecks) == 0 {
+		return nil
+	}
+	return healthChecks
+}
+
+// activeHealthCheckURI picks the path probed on every upstream of a group.
+// Caddy checks all upstreams of a handler with one URI, so the group path wins, then the
+// path the upstreams share. Upstreams with differing paths have none: "" is returned.
+func activeHealthCheckURI(group *models.UpstreamGroup, upstreams []models.Upstream) string {
+	if group.HealthCheckPath != "" {
+		return group.HealthCheckPath
+	}
+
+	switch paths := upstreamHealthCheckPaths(upstreams); len(paths) {
+	case 0:
+		return "/"
+	case 1:
+		return paths[0]
+	default:
+		return ""
+	}
+}
+
+// activeHealthCheckInterval returns the shortest interval configured on the upstreams, in seconds
+func activeHealthCheckInterval(upstreams []models.Upstream) int {
+	interval := 0
+	for _, u := range upstreams {
+		if u.HealthCheckInterval > 0 && (interval == 0 || u.HealthCheckInterval < interval) {
+			interval = u.HealthCheckInterval
+		}
+	}
+	if interval == 0 {
+		interval = 30
+	}
+	return interval
+}
+
+// buildTransport creates the http transport for an upstream's scheme.
+// Plain http needs no transport; https enables TLS and h2c enables cleartext HTTP/2.
+func buildTransport(u models.Upstream, certificates map[string]models.CustomCertificate) (map[string]interface{}, error) {
+	switch u.Scheme {
+	case "https":
+		tlsConfig := map[string]interface{}{}
+		if u.TLSServerName != "" {
+			tlsConfig["server_name"] = u.TLSServerName
+		}
+		if u.TLSInsecureSkipVerify {
+			tlsConfig["insecure_skip_verify"] = true
+		}
+		if u.TLSRootCAID != "" {
+			cert, ok := certificates[u.TLSRootCAID]
+			if !ok {
+				return nil, fmt.Errorf("root CA certificate %s not found", u.TLSRootCAID)
+			}
+			trusted, err := pemToBase64DER(cert.CertPEM)
+			if err != nil {
+				return nil, fmt.Errorf("root CA certificate %s: %w", cert.Name, err)
+			}
+			tlsConfig["ca"] = map[string]interface{}{
+				"provider":         "inline",
+				"trusted_ca_certs": trusted,
+			}
+		}
+		if u.TLSClientCertPath != "" {
+			tlsConfig["client_certificate_file"] = u.TLSClientCertPath
+			tlsConfig["client_certificate_key_file"] = u.TLSClientKeyPath
+		}
+		return map[string]interface{}{
+			"protocol": "http",
+			"tls":      tlsConfig,
+		}, nil
+
+	case "h2c":
+		// Caddy needs "2" alongside "h2c" to speak HTTP/2 over cleartext
+		return map[string]interface{}{
+			"protocol": "http",
+			"versions": []string{"h2c", "2"},
+		}, nil
+	}
+
+	return nil, nil
+}
+
+// pemToBase64DER converts every certificate block of a PEM bundle to base64 DER
+func pemToBase64DER(certPEM string) ([]string, error) {
+	var certs []string
+	rest := []byte(certPEM)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type == "CERTIFICATE" {
+			certs = append(certs, base64.StdEncoding.EncodeToString(block.Bytes))
+		}
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("no certificates found in PEM data")
+	}
+	return certs, nil
+}
+
+// HandleResponse represents a reverse_proxy rule run on matching upstream responses
+type HandleResponse struct {
+	Match      *ResponseMatch `json:"match,omitempty"`
+	StatusCode interface{}    `json:"status_code,omitempty"` // replaces the status, keeping the upstream body
+	Routes     []Route        `json:"routes,omitempty"`      // replaces the response
+}
+
+// ResponseMatch matches upstream responses; a one-digit status code matches its class
+type ResponseMatch struct {
+	StatusCode []int               `json:"status_code,omitempty"`
+	Headers    map[string][]string `json:"headers,omitempty"`
+}
+
+// proxyTimeoutKeys are the http transport durations settable on a reverse_proxy step
+var proxyTimeoutKeys = []string{"dial_timeout", "response_header_timeout", "read_timeout", "write_timeout"}
+
+// forwardedHeaders are the headers Caddy adds to proxied requests
+var forwardedHeaders = []string{"X-Forwarded-For", "X-Forwarded-Proto", "X-Forwarded-Host"}
+
+// proxyOptions is the parsed structured options of a reverse_proxy step
+type proxyOptions struct {
+	Headers         *Headers
+	FlushInterval   interface{}
+	RequestBuffers  int64
+	ResponseBuffers int64
+	Transport       map[string]interface{} // timeouts and keepalive for the http transport
+	HandleResponse  []HandleResponse
+}
+
+// parseProxyOptions reads the structured options of a reverse_proxy step:
+//   - headers.request / headers.response: set, add and delete operations
+//   - host_header: "upstream" for the upstream's host:port, or a literal Host value
+//   - forwarded_headers: "strip" keeps X-Forwarded-* from reaching the upstream
+//   - flush_interval: a duration, or -1 to flush immediately for SSE and streaming
+//   - request_buffers / response_buffers: bytes to buffer, -1 for unlimited
+//   - dial_timeout, response_header_timeout, read_timeout, write_timeout: durations
+//   - keepalive: an idle timeout duration or "off"; keepalive_max_idle_conns per host
+//   - handle_response: rules matching upstream status/headers
+func parseProxyOptions(config map[string]interface{}) (proxyOptions, error) {
+	var opts proxyOptions
+
+	headers := &Headers{}
+	if raw, ok := config["headers"].(map[string]interface{}); ok {
+		if request, ok := raw["request"].(map[string]interface{}); ok {
+			headers.Request = parseHeaderOps(request)
+		}
+		if response, ok := raw["response"].(map[string]interface{}); ok {
+			headers.Response = parseHeaderOps(response)
+		}
+	}
+	if host, _ := config["host_header"].(string); host != "" {
+		if host == "upstream" {
+			host = "{http.reverse_proxy.upstream.hostport}"
+		}
+		if headers.Request == nil {
+			headers.Request = &HeaderOps{}
+		}
+		if headers.Request.Set == nil {
+			headers.Request.Set = make(map[string][]string)
+		}
+		headers.Request.Set["Host"] = []string{host}
+	}
+	switch forwarded, _ := config["forwarded_headers"].(string); forwarded {
+	case "":
+	case "strip":
+		if headers.Request == nil {
+			headers.Request = &HeaderOps{}
+		}
+		headers.Request.Delete = append(headers.Request.Delete, forwardedHeaders...)
+	default:
+		return opts, fmt.Errorf("invalid forwarded_headers %q: must be strip or empty", forwarded)
+	}
+	if headers.Request != nil || headers.Response != nil {
+		opts.Headers = headers
+	}
+
+	switch flush := config["flush_interval"].(type) {
+	case nil:
+	case float64:
+		if flush != -1 {
+			return opts, fmt.Errorf("flush_interval must be a duration or -1")
+		}
+		opts.FlushInterval = -1
+	case string:
+		if _, err := parseDuration(flush); err != nil {
+			return opts, fmt.Errorf("invalid flush_interval: %w", err)
+		}
+		opts.FlushInterval = flush
+	default:
+		return opts, fmt.Errorf("flush_interval must be a duration or -1")
+	}
+
+	for key, target := range map[string]*int64{"request_buffers": &opts.RequestBuffers, "response_buffers": &opts.ResponseBuffers} {
+		if size, ok := config[key].(float64); ok {
+			if size < -1 || size != float64(int64(size)) {
+				return opts, fmt.Errorf("%s must be a byte count or -1", key)
+			}
+			*target = int64(size)
+		}
+	}
+
+	transport := map[string]interface{}{}
+	for _, key := range proxyTimeoutKeys {
+		value, ok := config[key].(string)
+		if !ok || value == "" {
+			continue
+		}
+		if _, err := parseDuration(value); err != nil {
+			return opts, fmt.Errorf("invalid %s: %w", key, err)
+		}
+		transport[key] = value
+	}
+	if keepalive, ok := config["keepalive"].(string); ok && keepalive != "" {
+		if keepalive == "off" {
+			transport["keep_alive"] = map[string]interface{}{"enabled": false}
+		} else {
+			if _, err := parseDuration(keepalive); err != nil {
+				return opts, fmt.Errorf("invalid keepalive: %w", err)
+			}
+			transport["keep_alive"] = map[string]interface{}{"enabled": true, "idle_timeout": keepalive}
+		}
+	}
+	if idle, ok := config["keepalive_max_idle_conns"].(float64); ok && idle > 0 {
+		keepAlive, _ := transport["keep_alive"].(map[string]interface{})
+		if keepAlive == nil {
+			keepAlive = map[string]interface{}{"enabled": true}
+			transport["keep_alive"] = keepAlive
+		}
+		keepAlive["max_idle_conns_per_host"] = int(idle)
+	}
+	if len(transport) > 0 {
+		opts.Transport = transport
+	}
+
+	// Durations in a raw transport would fail the same way on load
+	if raw, ok := config["transport"].(map[string]interface{}); ok {
+		for _, key := range proxyTimeoutKeys {
+			if value, ok := raw[key].(string); ok {
+				if _, err := parseDuration(value); err != nil {
+					return opts, fmt.Errorf("invalid transport %s: %w", key, err)
+				}
+			}
+		}
+	}
+
+	if rules, ok := config["handle_response"].([]interface{}); ok {
+		for i, raw := range rules {
+			rule, ok := raw.(map[string]interface{})
+			if !ok {
+				return opts, fmt.Errorf("handle_response %d must be an object", i+1)
+			}
+			handleResponse, err := parseHandleResponse(rule)
+			if err != nil {
+				return opts, fmt.Errorf("handle_response %d: %w", i+1, err)
+			}
+			opts.HandleResponse = append(opts.HandleResponse, handleResponse)
+		}
+	}
+
+	return opts, nil
+}
+
+// parseHandleResponse reads one handle_response rule: a "status" spec and/or
+// "headers" to match, and an action: status (replace the status code), static
+// (replace the response with status_code and body) or redirect (to location)
+func parseHandleResponse(rule map[string]interface{}) (HandleResponse, error) {
+	var hr HandleResponse
+
+	match := &ResponseMatch{}
+	if spec, _ := rule["status"].(string); spec != "" {
+		codes, err := parseResponseStatus(spec)
+		if err != nil {
+			return hr, err
+		}
+		match.StatusCode = codes
+	}
+	if headers, ok := rule["headers"].(map[string]interface{}); ok {
+		match.Headers = make(map[string][]string, len(headers))
+		for name, v := range headers {
+			if s, ok := v.(string); ok {
+				match.Headers[name] = []string{s}
+			} else {
+				match.Headers[name] = stringList(v)
+			}
+		}
+	}
+	if len(match.StatusCode) == 0 && len(match.Headers) == 0 {
+		return hr, fmt.Errorf("status or headers is required")
+	}
+	hr.Match = match
+
+	statusCode, _ := rule["status_code"].(float64)
+	if statusCode != 0 && (statusCode < 100 || statusCode > 599) {
+		return hr, fmt.Errorf("invalid status_code %v", statusCode)
+	}
+
+	switch action, _ := rule["action"].(string); action {
+	case "status":
+		if statusCode == 0 {
+			return hr, fmt.Errorf("status_code is required")
+		}
+		hr.StatusCode = int(statusCode)
+	case "static":
+		if statusCode == 0 {
+			statusCode = 200
+		}
+		body, _ := rule["body"].(string)
+		hr.Routes = []Route{{Handle: []Handler{{Handler: "static_response", StatusCode: int(statusCode), Body: body}}}}
+	case "redirect":
+		location, _ := rule["location"].(string)
+		if location == "" {
+			return hr, fmt.Errorf("location is required")
+		}
+		if statusCode == 0 {
+			statusCode = 302
+		}
+		hr.Routes = []Route{{Handle: []Handler{{
+			Handler:    "static_response",
+			Headers:    map[string][]string{"Location": {location}},
+			StatusCode: int(statusCode),
+		}}}}
+	default:
+		return hr, fmt.Errorf("invalid action %q: must be status, static or redirect", action)
+	}
+
+	return hr, nil
+}
+
+// parseResponseStatus parses a status spec for response matchers: codes ("502"),
+// classes ("5xx", matched by Caddy as the single digit) and small ranges ("500-504")
+func parseResponseStatus(spec string) ([]int, error) {
+	var codes []int
+	for _, term := range strings.Split(spec, ",") {
+		term = strings.ToLower(strings.TrimSpace(term))
+		switch {
+		case len(term) == 3 && strings.HasSuffix(term, "xx"):
+			class, err := strconv.Atoi(term[:1])
+			if err != nil || class < 1 || class > 5 {
+				return nil, fmt.Errorf("invalid status class %q", term)
+			}
+			codes = append(codes, class)
+		case strings.Contains(term, "-"):
+			from, to, _ := strings.Cut(term, "-")
+			lo, err1 := strconv.Atoi(strings.TrimSpace(from))
+			hi, err2 := strconv.Atoi(strings.TrimSpace(to))
+			if err1 != nil || err2 != nil || lo > hi || lo < 100 || hi > 599 || hi-lo > 99 {
+				return nil, fmt.Errorf("invalid status range %q", term)
+			}
+			for code := lo; code <= hi; code++ {
+				codes = append(codes, code)
+			}
+		default:
+			code, err := strconv.Atoi(term)
+			if err != nil || code < 100 || code > 599 {
+				return nil, fmt.Errorf("invalid status code %q", term)
+			}
+			codes = append(codes, code)
+		}
+	}
+	return codes, nil
+}
+
+// daysPattern matches the day unit Caddy accepts on top of Go durations
+var daysPattern = regexp.MustCompile(`(\d+)d`)
+
+// parseDuration parses a duration the way Caddy does: Go syntax plus days, e.g. "1d12h"
+func parseDuration(value string) (time.Duration, error) {
+	expanded := daysPattern.ReplaceAllStringFunc(value, func(days string) string {
+		n, _ := strconv.Atoi(strings.TrimSuffix(days, "d"))
+		return strconv.Itoa(n*24) + "h"
+	})
+	d, err := time.ParseDuration(expanded)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not a duration such as 500ms, 30s or 1m", value)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%q must not be negative", value)
+	}
+	return d, nil
+}
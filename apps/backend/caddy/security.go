@@ -0,0 +1,180 @@
+package caddy
+
+import (
+	"caddyadmin/models"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// securityPreset is the set of response headers a preset applies
+type securityPreset struct {
+	XContentTypeOptions string
+	XFrameOptions       string
+	ReferrerPolicy      string
+	PermissionsPolicy   string
+	CSP                 map[string][]string
+}
+
+var securityPresets = map[string]securityPreset{
+	"basic": {
+		XContentTypeOptions: "nosniff",
+		XFrameOptions:       "SAMEORIGIN",
+		ReferrerPolicy:      "strict-origin-when-cross-origin",
+	},
+	"strict": {
+		XContentTypeOptions: "nosniff",
+		XFrameOptions:       "DENY",
+		ReferrerPolicy:      "no-referrer",
+		PermissionsPolicy:   "camera=(), microphone=(), geolocation=(), payment=(), usb=()",
+		CSP: map[string][]string{
+			"default-src":     {"'self'"},
+			"object-src":      {"'none'"},
+			"base-uri":        {"'self'"},
+			"frame-ancestors": {"'none'"},
+		},
+	},
+}
+
+// BuildContentSecurityPolicy renders CSP directives as a header value.
+// default-src comes first, the rest alphabetically, so output is stable.
+func BuildContentSecurityPolicy(directives map[string][]string) string {
+	names := make([]string, 0, len(directives))
+	for name := range directives {
+		if name != "default-src" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if _, ok := directives["default-src"]; ok {
+		names = append([]string{"default-src"}, names...)
+	}
+
+	parts := make([]string, 0, len(names))
+	for _, name := range names {
+		if sources := directives[name]; len(sources) > 0 {
+			parts = append(parts, name+" "+strings.Join(sources, " "))
+		} else {
+			parts = append(parts, name) // value-less directives, e.g. upgrade-insecure-requests
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+// hstsValue builds the Strict-Transport-Security value from global settings,
+// or returns "" when HSTS is disabled
+func hstsValue(settings *models.GlobalSettings) string {
+	if settings == nil || !settings.HSTSEnabled {
+		return ""
+	}
+	value := fmt.Sprintf("max-age=%d", settings.HSTSMaxAge)
+	if settings.HSTSIncludeSubs {
+		value += "; includeSubDomains"
+	}
+	if settings.HSTSPreload {
+		value += "; preload"
+	}
+	return value
+}
+
+// securityHeaders resolves the preset and overrides of a site into response headers
+func securityHeaders(settings *models.MiddlewareSettings) map[string][]string {
+	headers := make(map[string][]string)
+	if settings == nil {
+		return headers
+	}
+
+	preset := securityPresets[settings.SecurityPreset]
+	apply := func(name, presetValue, override string) {
+		value := presetValue
+		if override != "" {
+			value = override
+		}
+		if value != "" && value != "off" {
+			headers[name] = []string{value}
+		}
+	}
+	apply("X-Content-Type-Options", preset.XContentTypeOptions, settings.XContentTypeOptions)
+	apply("X-Frame-Options", preset.XFrameOptions, settings.XFrameOptions)
+	apply("Referrer-Policy", preset.ReferrerPolicy, settings.ReferrerPolicy)
+	apply("Permissions-Policy", preset.PermissionsPolicy, settings.PermissionsPolicy)
+
+	// Site directives replace the preset's directive of the same name
+	directives := make(map[string][]string)
+	for name, sources := range preset.CSP {
+		directives[name] = sources
+	}
+	var overrides map[string][]string
+	if settings.CSPDirectivesJSON != "" {
+		json.Unmarshal([]byte(settings.CSPDirectivesJSON), &overrides)
+	}
+	for name, sources := range overrides {
+		directives[name] = sources
+	}
+	if len(directives) > 0 {
+		name := "Content-Security-Policy"
+		if settings.CSPReportOnly {
+			name = "Content-Security-Policy-Report-Only"
+		}
+		headers[name] = []string{BuildContentSecurityPolicy(directives)}
+	}
+	return headers
+}
+
+// buildSecurityHeadersRoute creates a non-terminal route setting HSTS and the site's
+// security headers on every response. Returns nil when there is nothing to set.
+func buildSecurityHeadersRoute(site *models.Site, settings *models.MiddlewareSettings, hsts string) *Route {
+	headers := securityHeaders(settings)
+	if hsts != "" {
+		headers["Strict-Transport-Security"] = []string{hsts}
+	}
+	if len(headers) == 0 {
+		return nil
+	}
+
+	match := Match{}
+	if len(site.Hosts) > 0 {
+		match.Host = site.Hosts
+	}
+	return &Route{
+		ID:    fmt.Sprintf("security_%s", site.ID),
+		Match: []Match{match},
+		Handle: []Handler{{
+			Handler: "headers",
+			// Deferred so these win over headers set by upstreams
+			Response: &HeaderOps{Set: headers, Deferred: true},
+		}},
+	}
+}
+
+// CheckHSTSPreload lists the reasons a site is not ready for HSTS preload submission
+func CheckHSTSPreload(site *models.Site, settings *models.GlobalSettings) []string {
+	var warnings []string
+
+	if settings == nil || !settings.HSTSEnabled {
+		return append(warnings, "HSTS is disabled in global settings")
+	}
+	if settings.HSTSMaxAge < 31536000 {
+		warnings = append(warnings, "max-age must be at least 31536000 seconds (1 year)")
+	}
+	if !settings.HSTSIncludeSubs {
+		warnings = append(warnings, "includeSubDomains is not set")
+	}
+	if !settings.HSTSPreload {
+		warnings = append(warnings, "preload directive is not set")
+	}
+
+	if !siteUsesTLS(site, settings) {
+		warnings = append(warnings, "site serves plain HTTP; HSTS is only sent over HTTPS")
+	} else if !site.AutoHTTPS {
+		warnings = append(warnings, "automatic HTTPS is disabled, so HTTP requests are not redirected to HTTPS")
+	}
+
+	for _, host := range site.Hosts {
+		if strings.HasPrefix(host, "*.") {
+			warnings = append(warnings, fmt.Sprintf("%s is a wildcard; preload applies to a registrable domain and all its subdomains", host))
+		}
+	}
+	return warnings
+}
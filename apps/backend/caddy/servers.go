@@ -0,0 +1,240 @@
+package caddy
+
+import (
+	"caddyadmin/models"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// HostConflict reports a host claimed by more than one site on the same listen address.
+// The first site in server order serves the host; the others never see its requests.
+type HostConflict struct {
+	Host          string   `json:"host"`
+	ListenAddress string   `json:"listen_address"`
+	SiteIDs       []string `json:"site_ids"`
+	SiteNames     []string `json:"site_names"`
+}
+
+// serverName returns the stable server name for a listen port
+func serverName(port int) string {
+	return fmt.Sprintf("srv_%d", port)
+}
+
+// groupSitesByPort groups enabled sites by listen port, each group in server order:
+// sites with only exact hosts first, then sites with wildcard hosts, then catch-all
+// sites without hosts, ties broken by creation time and ID so the order is stable
+func groupSitesByPort(sites []models.Site) (map[int][]*models.Site, []int) {
+	groups := make(map[int][]*models.Site)
+	for i := range sites {
+		if !sites[i].Enabled {
+			continue
+		}
+		groups[sites[i].ListenPort] = append(groups[sites[i].ListenPort], &sites[i])
+	}
+
+	tier := func(site *models.Site) int {
+		if len(site.Hosts) == 0 {
+			return 2
+		}
+		for _, host := range site.Hosts {
+			if strings.Contains(host, "*") {
+				return 1
+			}
+		}
+		return 0
+	}
+
+	var ports []int
+	for port, group := range groups {
+		ports = append(ports, port)
+		sort.SliceStable(group, func(i, j int) bool {
+			if ti, tj := tier(group[i]), tier(group[j]); ti != tj {
+				return ti < tj
+			}
+			if !group[i].CreatedAt.Equal(group[j].CreatedAt) {
+				return group[i].CreatedAt.Before(group[j].CreatedAt)
+			}
+			return group[i].ID < group[j].ID
+		})
+	}
+	sort.Ints(ports)
+	return groups, ports
+}
+
+// FindHostConflicts lists hosts claimed by more than one enabled site on the same port.
+// Sites must have their Hosts decoded.
+func FindHostConflicts(sites []models.Site) []HostConflict {
+	groups, ports := groupSitesByPort(sites)
+
+	var conflicts []HostConflict
+	for _, port := range ports {
+		byHost := make(map[string]*HostConflict)
+		var order []string
+		for _, site := range groups[port] {
+			seen := make(map[string]bool)
+			for _, host := range site.Hosts {
+				host = strings.ToLower(host)
+				if seen[host] {
+					continue
+				}
+				seen[host] = true
+
+				conflict, ok := byHost[host]
+				if !ok {
+					conflict = &HostConflict{Host: host, ListenAddress: fmt.Sprintf(":%d", port)}
+					byHost[host] = conflict
+					order = append(order, host)
+				}
+				conflict.SiteIDs = append(conflict.SiteIDs, site.ID)
+				conflict.SiteNames = append(conflict.SiteNames, site.Name)
+			}
+		}
+		for _, host := range order {
+			if len(byHost[host].SiteIDs) > 1 {
+				conflicts = append(conflicts, *byHost[host])
+			}
+		}
+	}
+	return conflicts
+}
+
+// buildServers creates one server per listen port. Each site becomes a terminal,
+// host-scoped subroute, and TLS, automatic HTTPS and server settings of the sites are merged.
+func (cb *ConfigBuilder) buildServers(sites []models.Site, routes map[string][]models.Route, redirectRules map[string][]models.RedirectRule, middleware map[string]*SiteMiddleware, upstreamGroups map[string]*models.UpstreamGroup, upstreams map[string][]models.Upstream, certificates map[string]models.CustomCertificate, settings *models.GlobalSettings, tlsConfigs map[string]models.TLSConfig, serverSettings map[string]models.ServerSettings, warnings *buildWarnings) (map[string]*HTTPServer, error) {
+	for _, conflict := range FindHostConflicts(sites) {
+		warnings.add("host %s on %s is claimed by sites %s; %s serves it",
+			conflict.Host, conflict.ListenAddress, strings.Join(conflict.SiteNames, ", "), conflict.SiteNames[0])
+	}
+
+	servers := make(map[string]*HTTPServer)
+	groups, ports := groupSitesByPort(sites)
+	for _, port := range ports {
+		group := groups[port]
+		server := &HTTPServer{
+			Listen: []string{fmt.Sprintf(":%d", port)},
+			Routes: []Route{},
+		}
+
+		// One TLS site makes the whole listener TLS
+		usesTLS := anySiteUsesTLS(group, settings)
+		for _, site := range group {
+			if usesTLS && !siteUsesTLS(site, settings) {
+				warnings.add("site %s is plain HTTP but shares :%d with TLS sites; it will be served over TLS", site.Name, port)
+			}
+		}
+
+		autoHTTPS := &AutoHTTPSConfig{}
+		autoHTTPSDisabled := 0
+		var policies []interface{}
+		hasCatchAllPolicy := false
+
+		for _, site := range group {
+			siteMiddleware := middleware[site.ID]
+			if hsts := hstsValue(settings); hsts != "" && usesTLS {
+				// Copy so the caller's middleware map is left untouched
+				withHSTS := SiteMiddleware{}
+				if siteMiddleware != nil {
+					withHSTS = *siteMiddleware
+				}
+				withHSTS.HSTS = hsts
+				siteMiddleware = &withHSTS
+			}
+
+			siteServer, err := cb.BuildSiteConfig(site, routes[site.ID], redirectRules[site.ID], siteMiddleware, upstreamGroups, upstreams, certificates, warnings)
+			if err != nil {
+				return nil, fmt.Errorf("failed to build config for site %s: %w", site.Name, err)
+			}
+
+			siteRoute := Route{
+				ID:       fmt.Sprintf("site_%s", site.ID),
+				Handle:   []Handler{{Handler: "subroute", Routes: siteServer.Routes}},
+				Terminal: true,
+			}
+			if len(site.Hosts) > 0 {
+				siteRoute.Match = []Match{{Host: site.Hosts}}
+			}
+			server.Routes = append(server.Routes, siteRoute)
+
+			// Error routes are scoped by host the same way, so sites keep their own pages
+			if siteServer.Errors != nil {
+				errorRoute := Route{
+					ID:       fmt.Sprintf("site_errors_%s", site.ID),
+					Match:    siteRoute.Match,
+					Handle:   []Handler{{Handler: "subroute", Routes: siteServer.Errors.Routes}},
+					Terminal: true,
+				}
+				if server.Errors == nil {
+					server.Errors = &HTTPErrorConfig{}
+				}
+				server.Errors.Routes = append(server.Errors.Routes, errorRoute)
+			}
+
+			// Automatic HTTPS: skip hosts of sites that opt out, disable only if all do
+			if !site.AutoHTTPS {
+				autoHTTPSDisabled++
+				autoHTTPS.Skip = append(autoHTTPS.Skip, site.Hosts...)
+			}
+
+			if !usesTLS {
+				continue
+			}
+
+			var tlsConfig *models.TLSConfig
+			if tc, ok := tlsConfigs[site.ID]; ok {
+				tlsConfig = &tc
+			}
+			policy := buildConnectionPolicy(site.Hosts, tlsConfig, settings)
+
+			// Serve the bound certificate and keep automatic HTTPS from managing these hosts
+			tag := siteCertificateTag(site, tlsConfig, certificates)
+			if tag == "" && tlsConfig != nil && tlsConfig.CertificateID != "" {
+				warnings.add("certificate %s bound to site %s not found, using automatic HTTPS", tlsConfig.CertificateID, site.Name)
+			}
+			if tag != "" {
+				policy.CertificateSelection = &CertificateSelection{AnyTag: []string{tag}}
+				autoHTTPS.SkipCerts = append(autoHTTPS.SkipCerts, site.Hosts...)
+			}
+
+			// A site without hosts acts as the catch-all policy; only the first one counts
+			if len(site.Hosts) == 0 {
+				if hasCatchAllPolicy {
+					continue
+				}
+				hasCatchAllPolicy = true
+			}
+			policies = append(policies, policy)
+		}
+
+		switch {
+		case autoHTTPSDisabled == len(group):
+			server.AutoHTTPS = &AutoHTTPSConfig{Disable: true}
+		case len(autoHTTPS.Skip) > 0 || len(autoHTTPS.SkipCerts) > 0:
+			server.AutoHTTPS = autoHTTPS
+		}
+
+		// TLS connection policies, matched by SNI with a catch-all for clients without it
+		if usesTLS {
+			if !hasCatchAllPolicy {
+				policies = append(policies, defaultConnectionPolicy(settings))
+			}
+			server.TLSConnectionPolicies = policies
+		}
+
+		// Timeouts, trusted proxies, protocols and listener wrappers apply to the whole listener
+		applyServerTuning(server, resolveServerTuning(group, serverSettings, settings, warnings))
+
+		servers[serverName(port)] = server
+	}
+
+	return servers, nil
+}
+
+func anySiteUsesTLS(sites []*models.Site, settings *models.GlobalSettings) bool {
+	for _, site := range sites {
+		if siteUsesTLS(site, settings) {
+			return true
+		}
+	}
+	return false
+}
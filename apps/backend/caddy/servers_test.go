@@ -0,0 +1,120 @@
+package caddy
+
+import (
+	"caddyadmin/models"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildServers(t *testing.T) {
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	site := func(id string, port int, tls bool, age int, hosts ...string) models.Site {
+		return models.Site{
+			ID:         id,
+			Name:       id,
+			Hosts:      hosts,
+			ListenPort: port,
+			TLSEnabled: tls,
+			Enabled:    true,
+			AutoHTTPS:  true,
+			CreatedAt:  created.Add(time.Duration(age) * time.Hour),
+		}
+	}
+
+	tests := []struct {
+		name      string
+		sites     []models.Site
+		server    string
+		order     []string // site subroute IDs in server order
+		tls       bool
+		conflicts []HostConflict
+		warning   string // substring of a build warning, empty when none is expected
+	}{
+		{
+			name:   "two sites on one port",
+			sites:  []models.Site{site("app", 8080, false, 1, "app.example.com"), site("api", 8080, false, 0, "api.example.com")},
+			server: "srv_8080",
+			order:  []string{"site_api", "site_app"},
+		},
+		{
+			name:   "duplicate host served by the older site",
+			sites:  []models.Site{site("new", 8080, false, 1, "shop.example.com", "www.example.com"), site("old", 8080, false, 0, "Shop.example.com")},
+			server: "srv_8080",
+			order:  []string{"site_old", "site_new"},
+			conflicts: []HostConflict{{
+				Host:          "shop.example.com",
+				ListenAddress: ":8080",
+				SiteIDs:       []string{"old", "new"},
+				SiteNames:     []string{"old", "new"},
+			}},
+			warning: "claimed by sites old, new; old serves it",
+		},
+		{
+			name:    "plain HTTP site sharing a port with a TLS site",
+			sites:   []models.Site{site("secure", 8443, true, 0, "secure.example.com"), site("plain", 8443, false, 1, "plain.example.com")},
+			server:  "srv_8443",
+			order:   []string{"site_secure", "site_plain"},
+			tls:     true,
+			warning: "site plain is plain HTTP but shares :8443 with TLS sites",
+		},
+		{
+			name: "site without hosts after host-scoped sites",
+			sites: []models.Site{
+				site("fallback", 8080, false, 0),
+				site("wildcard", 8080, false, 1, "*.example.com"),
+				site("exact", 8080, false, 2, "example.com"),
+			},
+			server: "srv_8080",
+			order:  []string{"site_exact", "site_wildcard", "site_fallback"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FindHostConflicts(tt.sites); !reflect.DeepEqual(got, tt.conflicts) {
+				t.Errorf("FindHostConflicts() = %+v, want %+v", got, tt.conflicts)
+			}
+
+			warnings := &buildWarnings{}
+			servers, err := NewConfigBuilder(nil).buildServers(tt.sites, nil, nil, nil, nil, nil, nil, &models.GlobalSettings{}, nil, nil, warnings)
+			if err != nil {
+				t.Fatalf("buildServers() error = %v", err)
+			}
+			if len(servers) != 1 {
+				t.Fatalf("servers = %d, want one shared server", len(servers))
+			}
+			server, ok := servers[tt.server]
+			if !ok {
+				t.Fatalf("servers = %v, want %s", servers, tt.server)
+			}
+
+			var order []string
+			for _, route := range server.Routes {
+				order = append(order, route.ID)
+			}
+			if !reflect.DeepEqual(order, tt.order) {
+				t.Errorf("routes = %v, want %v", order, tt.order)
+			}
+			// Only the catch-all site's subroute is left unmatched, and it must come last
+			for i, route := range server.Routes {
+				if len(route.Match) == 0 && i != len(server.Routes)-1 {
+					t.Errorf("route %s matches every host but is not last", route.ID)
+				}
+			}
+
+			if got := server.TLSConnectionPolicies != nil; got != tt.tls {
+				t.Errorf("tls = %v, want %v", got, tt.tls)
+			}
+
+			if tt.warning == "" {
+				if len(warnings.messages) > 0 {
+					t.Errorf("warnings = %q, want none", warnings.messages)
+				}
+			} else if !strings.Contains(strings.Join(warnings.messages, "\n"), tt.warning) {
+				t.Errorf("warnings = %q, want %q", warnings.messages, tt.warning)
+			}
+		})
+	}
+}
@@ -0,0 +1,398 @@
+package caddy
+
+import (
+	"caddyadmin/models"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Well-known ACME directories by provider
+var acmeDirectories = map[string]struct{ production, staging string }{
+	"letsencrypt": {"https://acme-v02.api.letsencrypt.org/directory", "https://acme-staging-v02.api.letsencrypt.org/directory"},
+	"zerossl":     {"https://acme.zerossl.com/v2/DV90", ""},
+	"buypass":     {"https://api.buypass.com/acme/directory", "https://api.test4.buypass.no/acme/directory"},
+}
+
+// ACMEIssuer represents the acme issuer module of an automation policy
+type ACMEIssuer struct {
+	Module               string                 `json:"module"`
+	CA                   string                 `json:"ca,omitempty"`
+	Email                string                 `json:"email,omitempty"`
+	ExternalAccount      *ACMEExternalAccount   `json:"external_account,omitempty"`
+	PreferredChains      *ACMEPreferredChains   `json:"preferred_chains,omitempty"`
+	TrustedRootsPEMFiles []string               `json:"trusted_roots_pem_files,omitempty"`
+	Challenges           map[string]interface{} `json:"challenges,omitempty"`
+}
+
+// ACMEExternalAccount holds External Account Binding credentials
+type ACMEExternalAccount struct {
+	KeyID  string `json:"key_id"`
+	MACKey string `json:"mac_key"`
+}
+
+// ACMEPreferredChains selects among alternate chains offered by the CA
+type ACMEPreferredChains struct {
+	RootCommonName []string `json:"root_common_name,omitempty"`
+}
+
+// TLSConnectionPolicy represents a server's TLS handshake settings for matching connections
+type TLSConnectionPolicy struct {
+	Match        *TLSConnectionMatch `json:"match,omitempty"`
+	ProtocolMin  string              `json:"protocol_min,omitempty"`
+	CipherSuites []string            `json:"cipher_suites,omitempty"`
+	Curves       []string            `json:"curves,omitempty"`
+	ALPN         []string            `json:"alpn,omitempty"`
+
+	CertificateSelection *CertificateSelection `json:"certificate_selection,omitempty"`
+}
+
+// CertificateSelection picks loaded certificates for a connection policy by tag
+type CertificateSelection struct {
+	AnyTag []string `json:"any_tag,omitempty"`
+}
+
+// PEMFilePair represents a certificate and key loaded from files by load_files
+type PEMFilePair struct {
+	Certificate string   `json:"certificate"`
+	Key         string   `json:"key"`
+	Tags        []string `json:"tags,omitempty"`
+}
+
+// TLSConnectionMatch selects connection policies by ClientHello values
+type TLSConnectionMatch struct {
+	SNI []string `json:"sni,omitempty"`
+}
+
+// ParseStringList reads a list stored either as a JSON array or comma-separated
+func ParseStringList(value string) []string {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil
+	}
+	if strings.HasPrefix(value, "[") {
+		var list []string
+		if err := json.Unmarshal([]byte(value), &list); err == nil {
+			return list
+		}
+	}
+
+	var list []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
+
+// siteUsesTLS reports whether a site's server terminates TLS: sites on the HTTP
+// port never do, otherwise TLS is on when enabled or when serving the HTTPS port
+func siteUsesTLS(site *models.Site, settings *models.GlobalSettings) bool {
+	httpPort, httpsPort := 80, 443
+	if settings != nil {
+		if settings.HTTPPort > 0 {
+			httpPort = settings.HTTPPort
+		}
+		if settings.HTTPSPort > 0 {
+			httpsPort = settings.HTTPSPort
+		}
+	}
+	if site.ListenPort == httpPort {
+		return false
+	}
+	return site.TLSEnabled || site.ListenPort == httpsPort
+}
+
+// buildConnectionPolicy creates the SNI-matched connection policy for a site's hosts.
+// Values missing from the site's TLS config fall back to the global defaults.
+func buildConnectionPolicy(hosts []string, tlsConfig *models.TLSConfig, settings *models.GlobalSettings) TLSConnectionPolicy {
+	policy := defaultConnectionPolicy(settings)
+	if len(hosts) > 0 {
+		policy.Match = &TLSConnectionMatch{SNI: hosts}
+	}
+	if tlsConfig == nil {
+		return policy
+	}
+
+	if isSupportedTLSVersion(tlsConfig.MinVersion) {
+		policy.ProtocolMin = tlsConfig.MinVersion
+	}
+	if suites := ParseStringList(tlsConfig.CipherSuites); len(suites) > 0 {
+		policy.CipherSuites = suites
+	}
+	if curves := ParseStringList(tlsConfig.Curves); len(curves) > 0 {
+		policy.Curves = curves
+	}
+	if alpn := ParseStringList(tlsConfig.ALPN); len(alpn) > 0 {
+		policy.ALPN = alpn
+	}
+	return policy
+}
+
+// defaultConnectionPolicy creates the catch-all policy from the global TLS defaults.
+// It also serves clients that send no SNI, which no site policy matches.
+func defaultConnectionPolicy(settings *models.GlobalSettings) TLSConnectionPolicy {
+	policy := TLSConnectionPolicy{ProtocolMin: "tls1.2"}
+	if settings == nil {
+		return policy
+	}
+
+	if isSupportedTLSVersion(settings.DefaultMinTLS) {
+		policy.ProtocolMin = settings.DefaultMinTLS
+	}
+	policy.CipherSuites = ParseStringList(settings.DefaultCipherSuites)
+	policy.Curves = ParseStringList(settings.DefaultCurves)
+	policy.ALPN = ParseStringList(settings.DefaultALPN)
+	return policy
+}
+
+// certificateTag is the tag an uploaded certificate is loaded with, for certificate_selection
+func certificateTag(certID string) string {
+	return "cert_" + certID
+}
+
+// siteCertificateTag returns the tag of the certificate a site is bound to: an uploaded
+// certificate or its own file pair. Returns "" when the site uses automatic certificates.
+func siteCertificateTag(site *models.Site, tlsConfig *models.TLSConfig, certificates map[string]models.CustomCertificate) string {
+	if tlsConfig == nil {
+		return ""
+	}
+	if tlsConfig.CertificateID != "" {
+		if _, ok := certificates[tlsConfig.CertificateID]; !ok {
+			return ""
+		}
+		return certificateTag(tlsConfig.CertificateID)
+	}
+	if tlsConfig.CustomCertPath != "" && tlsConfig.CustomKeyPath != "" {
+		return "files_" + site.ID
+	}
+	return ""
+}
+
+// buildAutomationPolicy creates the certificate automation policy for a site's hosts.
+// Site TLS settings override the global defaults field by field.
+func buildAutomationPolicy(site *models.Site, tlsConfig *models.TLSConfig, settings *models.GlobalSettings, dnsProviders map[string]models.DNSProvider) (*TLSPolicy, error) {
+	if settings == nil {
+		settings = &models.GlobalSettings{}
+	}
+	siteTLS := tlsConfig
+	if siteTLS == nil {
+		siteTLS = &models.TLSConfig{}
+	}
+
+	directory, account, err := resolveIssuerAccount(siteTLS, settings)
+	if err != nil {
+		return nil, err
+	}
+
+	issuer := ACMEIssuer{
+		Module:          "acme",
+		CA:              directory,
+		Email:           siteOrGlobal(siteTLS.ACMEEmail, settings.DefaultACMEEmail),
+		ExternalAccount: account,
+	}
+
+	if chains := ParseStringList(siteOrGlobal(siteTLS.ACMEPreferredChains, settings.DefaultACMEPreferredChains)); len(chains) > 0 {
+		issuer.PreferredChains = &ACMEPreferredChains{RootCommonName: chains}
+	}
+	if roots := siteOrGlobal(siteTLS.ACMETrustedRoots, settings.DefaultACMETrustedRoots); roots != "" {
+		issuer.TrustedRootsPEMFiles = []string{roots}
+	}
+
+	// DNS challenge for wildcard certificates
+	if tlsConfig != nil && tlsConfig.WildcardCert && tlsConfig.DNSProviderID != "" {
+		provider, ok := dnsProviders[tlsConfig.DNSProviderID]
+		if !ok {
+			return nil, fmt.Errorf("DNS provider %s not found", tlsConfig.DNSProviderID)
+		}
+
+		var creds map[string]interface{}
+		if err := json.Unmarshal([]byte(provider.Credentials), &creds); err != nil {
+			return nil, fmt.Errorf("error parsing credentials for provider %s: %w", provider.Name, err)
+		}
+
+		providerConfig := map[string]interface{}{
+			"name": provider.Provider,
+		}
+		for k, v := range creds {
+			providerConfig[k] = v
+		}
+		issuer.Challenges = map[string]interface{}{
+			"dns": map[string]interface{}{
+				"provider": providerConfig,
+			},
+		}
+	}
+
+	// Public CAs refuse internal names; a private CA (step-ca, Pebble) may issue them
+	subjects := site.Hosts
+	if siteOrGlobal(siteTLS.ACMEDirectory, settings.DefaultACMEDirectory) == "" {
+		subjects = nil
+		for _, host := range site.Hosts {
+			if qualifiesForPublicCert(host) {
+				subjects = append(subjects, host)
+			}
+		}
+	}
+	if len(subjects) == 0 {
+		return nil, nil
+	}
+
+	return &TLSPolicy{
+		Subjects: subjects,
+		Issuers:  []interface{}{issuer},
+		KeyType:  siteOrGlobal(siteTLS.KeyType, settings.DefaultKeyType),
+		OnDemand: siteTLS.OnDemandTLS || settings.OnDemandTLSEnabled,
+	}, nil
+}
+
+// siteOrGlobal returns a site value, falling back to the global default when it is empty
+func siteOrGlobal(siteValue, globalValue string) string {
+	if siteValue != "" {
+		return siteValue
+	}
+	return globalValue
+}
+
+// resolveIssuerAccount resolves the directory and EAB credentials a site issues with.
+// EAB credentials are bound to one CA account, so the global pair is only inherited
+// by sites issuing from the same CA as the global settings. ZeroSSL refuses ACME
+// accounts without EAB, so a ZeroSSL directory without credentials is an error.
+func resolveIssuerAccount(siteTLS *models.TLSConfig, settings *models.GlobalSettings) (string, *ACMEExternalAccount, error) {
+	directory, err := acmeDirectory(
+		siteOrGlobal(siteTLS.ACMEProvider, settings.DefaultACMEProvider),
+		siteOrGlobal(siteTLS.ACMEDirectory, settings.DefaultACMEDirectory),
+		siteTLS.ACMEStaging || settings.DefaultACMEStaging,
+	)
+	if err != nil {
+		return "", nil, err
+	}
+
+	keyID, macKey := siteTLS.ACMEEABKeyID, siteTLS.ACMEEABMACKey
+	globalDirectory, _ := acmeDirectory(settings.DefaultACMEProvider, settings.DefaultACMEDirectory, settings.DefaultACMEStaging)
+	if keyID == "" && directory == globalDirectory {
+		keyID, macKey = settings.DefaultACMEEABKeyID, settings.DefaultACMEEABMACKey
+	}
+	if keyID == "" {
+		if directory == acmeDirectories["zerossl"].production {
+			return "", nil, fmt.Errorf("ZeroSSL requires EAB credentials: set the EAB key ID and MAC key from the ZeroSSL developer dashboard")
+		}
+		return directory, nil, nil
+	}
+	return directory, &ACMEExternalAccount{KeyID: keyID, MACKey: macKey}, nil
+}
+
+// ValidateIssuer checks that the issuer a site would use, after falling back to the
+// global defaults, can register an account. Pass a nil tlsConfig for the global issuer.
+func ValidateIssuer(tlsConfig *models.TLSConfig, settings *models.GlobalSettings) error {
+	if tlsConfig == nil {
+		tlsConfig = &models.TLSConfig{}
+	}
+	if settings == nil {
+		settings = &models.GlobalSettings{}
+	}
+	_, _, err := resolveIssuerAccount(tlsConfig, settings)
+	return err
+}
+
+// onDemandAskURL returns the permission endpoint for on-demand issuance
+func onDemandAskURL(settings *models.GlobalSettings) string {
+	if settings != nil && settings.OnDemandAskURL != "" {
+		return settings.OnDemandAskURL
+	}
+	return "http://localhost:4000/api/tls/ask"
+}
+
+// MatchSiteHost reports whether host is served by one of the patterns, where a
+// leading "*." matches exactly one label like Caddy's host matcher
+func MatchSiteHost(patterns []string, host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(strings.TrimSuffix(pattern, "."))
+		if pattern == host {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(pattern, "*."); ok {
+			if label, rest, found := strings.Cut(host, "."); found && label != "" && rest == suffix {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acmeDirectory resolves the directory URL for a provider; an explicit URL always wins
+func acmeDirectory(provider, directory string, staging bool) (string, error) {
+	if directory != "" {
+		return directory, nil
+	}
+	if provider == "" {
+		provider = "letsencrypt"
+	}
+	if provider == "custom" {
+		return "", fmt.Errorf("ACME provider custom requires a directory URL")
+	}
+
+	dirs, ok := acmeDirectories[provider]
+	if !ok {
+		return "", fmt.Errorf("unknown ACME provider %q", provider)
+	}
+	if staging {
+		if dirs.staging == "" {
+			return "", fmt.Errorf("ACME provider %s has no staging environment", provider)
+		}
+		return dirs.staging, nil
+	}
+	return dirs.production, nil
+}
+
+// qualifiesForPublicCert reports whether a public CA could issue a certificate for host
+func qualifiesForPublicCert(host string) bool {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if net.ParseIP(host) != nil || !strings.Contains(host, ".") {
+		return false
+	}
+	for _, suffix := range []string{".localhost", ".local", ".internal", ".home.arpa"} {
+		if strings.HasSuffix(host, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckACMEDirectory fetches an ACME directory and verifies it advertises the
+// endpoints an issuer needs, so a CA (or a Pebble-like stand-in) can be tried before saving
+func CheckACMEDirectory(directoryURL string, insecureSkipVerify bool) (map[string]interface{}, error) {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: insecureSkipVerify},
+		},
+	}
+
+	resp, err := client.Get(directoryURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch ACME directory: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("ACME directory returned status %d", resp.StatusCode)
+	}
+
+	var directory map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&directory); err != nil {
+		return nil, fmt.Errorf("invalid ACME directory: %w", err)
+	}
+	for _, key := range []string{"newNonce", "newAccount", "newOrder"} {
+		if _, ok := directory[key].(string); !ok {
+			return nil, fmt.Errorf("ACME directory is missing %s", key)
+		}
+	}
+	return directory, nil
+}
@@ -0,0 +1,193 @@
+package caddy
+
+import (
+	"caddyadmin/models"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// newACMEDirectoryServer starts a Pebble-like stand-in serving an ACME directory document
+func newACMEDirectoryServer(t *testing.T, directory map[string]interface{}) *httptest.Server {
+	t.Helper()
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/dir" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(directory)
+	}))
+	// Handshakes rejected by the verifying client are expected, keep them out of the output
+	server.Config.ErrorLog = log.New(io.Discard, "", 0)
+	server.StartTLS()
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestCheckACMEDirectory(t *testing.T) {
+	server := newACMEDirectoryServer(t, map[string]interface{}{
+		"newNonce":   "https://pebble.test/nonce-plz",
+		"newAccount": "https://pebble.test/sign-me-up",
+		"newOrder":   "https://pebble.test/order-plz",
+		"meta":       map[string]interface{}{"externalAccountRequired": false},
+	})
+
+	directory, err := CheckACMEDirectory(server.URL+"/dir", true)
+	if err != nil {
+		t.Fatalf("CheckACMEDirectory() error = %v", err)
+	}
+	if got := directory["newOrder"]; got != "https://pebble.test/order-plz" {
+		t.Errorf("newOrder = %v, want the advertised endpoint", got)
+	}
+
+	// The stand-in uses a self-signed certificate, rejected unless verification is skipped
+	if _, err := CheckACMEDirectory(server.URL+"/dir", false); err == nil {
+		t.Error("CheckACMEDirectory() with verification accepted a self-signed certificate")
+	}
+	if _, err := CheckACMEDirectory(server.URL+"/missing", true); err == nil || !strings.Contains(err.Error(), "status 404") {
+		t.Errorf("CheckACMEDirectory() on a missing path error = %v, want status 404", err)
+	}
+
+	incomplete := newACMEDirectoryServer(t, map[string]interface{}{
+		"newNonce":   "https://pebble.test/nonce-plz",
+		"newAccount": "https://pebble.test/sign-me-up",
+	})
+	if _, err := CheckACMEDirectory(incomplete.URL+"/dir", true); err == nil || !strings.Contains(err.Error(), "newOrder") {
+		t.Errorf("CheckACMEDirectory() without newOrder error = %v, want missing newOrder", err)
+	}
+}
+
+func TestBuildAutomationPolicy(t *testing.T) {
+	site := &models.Site{Name: "example", Hosts: []string{"example.com", "www.example.com"}}
+	global := &models.GlobalSettings{
+		DefaultACMEDirectory:       "https://ca.example.com/acme/directory",
+		DefaultACMEEmail:           "ops@example.com",
+		DefaultACMEEABKeyID:        "global-kid",
+		DefaultACMEEABMACKey:       "global-mac",
+		DefaultACMEPreferredChains: "ISRG Root X1",
+		DefaultKeyType:             "p256",
+	}
+
+	tests := []struct {
+		name      string
+		tlsConfig *models.TLSConfig
+		settings  *models.GlobalSettings
+		ca        string
+		account   *ACMEExternalAccount
+		chains    *ACMEPreferredChains
+		keyType   string
+	}{
+		{
+			name:     "global defaults",
+			settings: global,
+			ca:       "https://ca.example.com/acme/directory",
+			account:  &ACMEExternalAccount{KeyID: "global-kid", MACKey: "global-mac"},
+			chains:   &ACMEPreferredChains{RootCommonName: []string{"ISRG Root X1"}},
+			keyType:  "p256",
+		},
+		{
+			name: "site overrides global",
+			tlsConfig: &models.TLSConfig{
+				ACMEDirectory:       "https://site-ca.example.com/directory",
+				ACMEEABKeyID:        "site-kid",
+				ACMEEABMACKey:       "site-mac",
+				ACMEPreferredChains: `["Site Root A","Site Root B"]`,
+				KeyType:             "rsa4096",
+			},
+			settings: global,
+			ca:       "https://site-ca.example.com/directory",
+			account:  &ACMEExternalAccount{KeyID: "site-kid", MACKey: "site-mac"},
+			chains:   &ACMEPreferredChains{RootCommonName: []string{"Site Root A", "Site Root B"}},
+			keyType:  "rsa4096",
+		},
+		{
+			name:      "global EAB not inherited by another CA",
+			tlsConfig: &models.TLSConfig{ACMEDirectory: "https://site-ca.example.com/directory"},
+			settings:  global,
+			ca:        "https://site-ca.example.com/directory",
+			chains:    &ACMEPreferredChains{RootCommonName: []string{"ISRG Root X1"}},
+			keyType:   "p256",
+		},
+		{
+			name:      "staging provider",
+			tlsConfig: &models.TLSConfig{ACMEProvider: "letsencrypt", ACMEStaging: true},
+			settings:  &models.GlobalSettings{},
+			ca:        "https://acme-staging-v02.api.letsencrypt.org/directory",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := buildAutomationPolicy(site, tt.tlsConfig, tt.settings, nil)
+			if err != nil {
+				t.Fatalf("buildAutomationPolicy() error = %v", err)
+			}
+			if policy == nil || len(policy.Issuers) != 1 {
+				t.Fatalf("buildAutomationPolicy() = %+v, want one issuer", policy)
+			}
+			issuer, ok := policy.Issuers[0].(ACMEIssuer)
+			if !ok {
+				t.Fatalf("issuer = %T, want ACMEIssuer", policy.Issuers[0])
+			}
+
+			if issuer.CA != tt.ca {
+				t.Errorf("ca = %q, want %q", issuer.CA, tt.ca)
+			}
+			if !reflect.DeepEqual(issuer.ExternalAccount, tt.account) {
+				t.Errorf("external_account = %+v, want %+v", issuer.ExternalAccount, tt.account)
+			}
+			if !reflect.DeepEqual(issuer.PreferredChains, tt.chains) {
+				t.Errorf("preferred_chains = %+v, want %+v", issuer.PreferredChains, tt.chains)
+			}
+			if policy.KeyType != tt.keyType {
+				t.Errorf("key_type = %q, want %q", policy.KeyType, tt.keyType)
+			}
+		})
+	}
+}
+
+func TestBuildAutomationPolicyZeroSSLRequiresEAB(t *testing.T) {
+	site := &models.Site{Name: "example", Hosts: []string{"example.com"}}
+
+	_, err := buildAutomationPolicy(site, &models.TLSConfig{ACMEProvider: "zerossl"}, &models.GlobalSettings{}, nil)
+	if err == nil || !strings.Contains(err.Error(), "EAB") {
+		t.Fatalf("buildAutomationPolicy() error = %v, want missing EAB credentials", err)
+	}
+
+	policy, err := buildAutomationPolicy(site, &models.TLSConfig{ACMEProvider: "zerossl"}, &models.GlobalSettings{
+		DefaultACMEProvider:  "zerossl",
+		DefaultACMEEABKeyID:  "kid",
+		DefaultACMEEABMACKey: "mac",
+	}, nil)
+	if err != nil {
+		t.Fatalf("buildAutomationPolicy() with global EAB error = %v", err)
+	}
+	if issuer := policy.Issuers[0].(ACMEIssuer); issuer.ExternalAccount == nil || issuer.ExternalAccount.KeyID != "kid" {
+		t.Errorf("external_account = %+v, want the inherited global pair", issuer.ExternalAccount)
+	}
+}
+
+func TestBuildAutomationPolicyOnDemand(t *testing.T) {
+	onDemand := &models.TLSConfig{OnDemandTLS: true}
+
+	policy, err := buildAutomationPolicy(&models.Site{Name: "public", Hosts: []string{"*.example.com"}}, onDemand, &models.GlobalSettings{}, nil)
+	if err != nil {
+		t.Fatalf("buildAutomationPolicy() error = %v", err)
+	}
+	if policy == nil || !policy.OnDemand || !reflect.DeepEqual(policy.Subjects, []string{"*.example.com"}) {
+		t.Errorf("buildAutomationPolicy() = %+v, want an on-demand policy scoped to the site's hosts", policy)
+	}
+
+	// Without subjects the policy would be a catch-all issuing with this site's account
+	for _, site := range []*models.Site{{Name: "internal", Hosts: []string{"app.internal"}}, {Name: "hostless"}} {
+		if policy, err := buildAutomationPolicy(site, onDemand, &models.GlobalSettings{}, nil); err != nil || policy != nil {
+			t.Errorf("%s = %+v, %v, want no policy", site.Name, policy, err)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+package caddy
+
+import (
+	"caddyadmin/models"
+	"fmt"
+	"strings"
+)
+
+// TrustedProxies represents a server's static trusted proxy ranges
+type TrustedProxies struct {
+	Source string   `json:"source"`
+	Ranges []string `json:"ranges"`
+}
+
+// ListenerWrapper represents a listener wrapper module, applied in order to accepted connections
+type ListenerWrapper struct {
+	Wrapper string   `json:"wrapper"`
+	Allow   []string `json:"allow,omitempty"`
+}
+
+// trustedProxyPresets expands the named ranges accepted in trusted proxy lists
+var trustedProxyPresets = map[string][]string{
+	// Same ranges as Caddy's private_ranges shortcut
+	"private_ranges": {"192.168.0.0/16", "172.16.0.0/12", "10.0.0.0/8", "127.0.0.1/8", "fd00::/8", "::1"},
+	// Published at https://www.cloudflare.com/ips/
+	"cloudflare": {
+		"173.245.48.0/20", "103.21.244.0/22", "103.22.200.0/22", "103.31.4.0/22",
+		"141.101.64.0/18", "108.162.192.0/18", "190.93.240.0/20", "188.114.96.0/20",
+		"197.234.240.0/22", "198.41.128.0/17", "162.158.0.0/15", "104.16.0.0/13",
+		"104.24.0.0/14", "172.64.0.0/13", "131.0.72.0/22",
+		"2400:cb00::/32", "2606:4700::/32", "2803:f800::/32", "2405:b500::/32",
+		"2405:8100::/32", "2a06:98c0::/29", "2c0f:f248::/32",
+	},
+}
+
+// supportedServerProtocols are the HTTP versions a Caddy server can speak
+var supportedServerProtocols = map[string]bool{"h1": true, "h2": true, "h2c": true, "h3": true}
+
+// ServerTuning holds the HTTP server options of a listener; empty values are left to Caddy
+type ServerTuning struct {
+	ReadTimeout        string   `json:"read_timeout"`
+	ReadHeaderTimeout  string   `json:"read_header_timeout"`
+	WriteTimeout       string   `json:"write_timeout"`
+	IdleTimeout        string   `json:"idle_timeout"`
+	MaxHeaderBytes     int      `json:"max_header_bytes"`
+	TrustedProxies     []string `json:"trusted_proxies"`   // IPs, CIDRs or a preset: private_ranges, cloudflare
+	ClientIPHeaders    []string `json:"client_ip_headers"` // read from trusted proxies only; Caddy defaults to X-Forwarded-For
+	Protocols          []string `json:"protocols"`
+	ProxyProtocol      *bool    `json:"proxy_protocol"`
+	ProxyProtocolAllow []string `json:"proxy_protocol_allow"`
+	StrictSNIHost      *bool    `json:"strict_sni_host"`
+}
+
+// GlobalServerTuning reads the server defaults of the global settings
+func GlobalServerTuning(settings *models.GlobalSettings) ServerTuning {
+	if settings == nil {
+		return ServerTuning{}
+	}
+	tuning := ServerTuning{
+		ReadTimeout:        settings.ServerReadTimeout,
+		ReadHeaderTimeout:  settings.ServerReadHeaderTimeout,
+		WriteTimeout:       settings.ServerWriteTimeout,
+		IdleTimeout:        settings.ServerIdleTimeout,
+		MaxHeaderBytes:     settings.ServerMaxHeaderBytes,
+		TrustedProxies:     ParseStringList(settings.ServerTrustedProxies),
+		ClientIPHeaders:    ParseStringList(settings.ServerClientIPHeaders),
+		Protocols:          ParseStringList(settings.ServerProtocols),
+		ProxyProtocolAllow: ParseStringList(settings.ServerProxyProtocolAllow),
+	}
+	if settings.ServerProxyProtocol {
+		tuning.ProxyProtocol = &settings.ServerProxyProtocol
+	}
+	if settings.ServerStrictSNIHost {
+		tuning.StrictSNIHost = &settings.ServerStrictSNIHost
+	}
+	return tuning
+}
+
+// SiteServerTuning reads a site's server overrides
+func SiteServerTuning(settings models.ServerSettings) ServerTuning {
+	return ServerTuning{
+		ReadTimeout:        settings.ReadTimeout,
+		ReadHeaderTimeout:  settings.ReadHeaderTimeout,
+		WriteTimeout:       settings.WriteTimeout,
+		IdleTimeout:        settings.IdleTimeout,
+		MaxHeaderBytes:     settings.MaxHeaderBytes,
+		TrustedProxies:     ParseStringList(settings.TrustedProxies),
+		ClientIPHeaders:    ParseStringList(settings.ClientIPHeaders),
+		Protocols:          ParseStringList(settings.Protocols),
+		ProxyProtocol:      settings.ProxyProtocol,
+		ProxyProtocolAllow: ParseStringList(settings.ProxyProtocolAllow),
+		StrictSNIHost:      settings.StrictSNIHost,
+	}
+}
+
+// expandTrustedProxies replaces presets with their ranges and drops duplicates
+func expandTrustedProxies(values []string) []string {
+	seen := make(map[string]bool)
+	var ranges []string
+	for _, value := range values {
+		expanded, ok := trustedProxyPresets[strings.ToLower(value)]
+		if !ok {
+			expanded = []string{value}
+		}
+		for _, r := range expanded {
+			if !seen[r] {
+				seen[r] = true
+				ranges = append(ranges, r)
+			}
+		}
+	}
+	return ranges
+}
+
+// resolveServerTuning merges the global defaults with the overrides of the sites sharing
+// a server. Sites are in server order: for single values the first site setting one wins
+// and later differing values are reported; trusted proxies of all of them are combined,
+// since every site's load balancer must be trusted on the shared listener.
+func resolveServerTuning(group []*models.Site, overrides map[string]models.ServerSettings, settings *models.GlobalSettings, warnings *buildWarnings) ServerTuning {
+	tuning := GlobalServerTuning(settings)
+	setBy := make(map[string]string)
+
+	pick := func(site *models.Site, option string, set bool, current, value interface{}, apply func()) {
+		if !set {
+			return
+		}
+		if owner, taken := setBy[option]; taken {
+			if fmt.Sprint(current) != fmt.Sprint(value) {
+				warnings.add("site %s sets %s on :%d but site %s already set it; keeping %v", site.Name, option, site.ListenPort, owner, current)
+			}
+			return
+		}
+		setBy[option] = site.Name
+		apply()
+	}
+
+	for _, site := range group {
+		override, ok := overrides[site.ID]
+		if !ok {
+			continue
+		}
+		o := SiteServerTuning(override)
+
+		pick(site, "read_timeout", o.ReadTimeout != "", tuning.ReadTimeout, o.ReadTimeout, func() { tuning.ReadTimeout = o.ReadTimeout })
+		pick(site, "read_header_timeout", o.ReadHeaderTimeout != "", tuning.ReadHeaderTimeout, o.ReadHeaderTimeout, func() { tuning.ReadHeaderTimeout = o.ReadHeaderTimeout })
+		pick(site, "write_timeout", o.WriteTimeout != "", tuning.WriteTimeout, o.WriteTimeout, func() { tuning.WriteTimeout = o.WriteTimeout })
+		pick(site, "idle_timeout", o.IdleTimeout != "", tuning.IdleTimeout, o.IdleTimeout, func() { tuning.IdleTimeout = o.IdleTimeout })
+		pick(site, "max_header_bytes", o.MaxHeaderBytes > 0, tuning.MaxHeaderBytes, o.MaxHeaderBytes, func() { tuning.MaxHeaderBytes = o.MaxHeaderBytes })
+		pick(site, "client_ip_headers", len(o.ClientIPHeaders) > 0, tuning.ClientIPHeaders, o.ClientIPHeaders, func() { tuning.ClientIPHeaders = o.ClientIPHeaders })
+		pick(site, "protocols", len(o.Protocols) > 0, tuning.Protocols, o.Protocols, func() { tuning.Protocols = o.Protocols })
+		pick(site, "strict_sni_host", o.StrictSNIHost != nil, boolValue(tuning.StrictSNIHost), boolValue(o.StrictSNIHost), func() { tuning.StrictSNIHost = o.StrictSNIHost })
+		pick(site, "proxy_protocol", o.ProxyProtocol != nil, boolValue(tuning.ProxyProtocol), boolValue(o.ProxyProtocol), func() {
+			tuning.ProxyProtocol = o.ProxyProtocol
+			if len(o.ProxyProtocolAllow) > 0 {
+				tuning.ProxyProtocolAllow = o.ProxyProtocolAllow
+			}
+		})
+
+		tuning.TrustedProxies = append(tuning.TrustedProxies, o.TrustedProxies...)
+	}
+
+	return tuning
+}
+
+// boolValue dereferences an optional flag for reporting
+func boolValue(b *bool) bool {
+	return b != nil && *b
+}
+
+// applyServerTuning sets the tuning options on a server. The PROXY protocol header is
+// read before the TLS handshake, so the proxy_protocol wrapper is placed ahead of tls.
+func applyServerTuning(server *HTTPServer, tuning ServerTuning) {
+	server.ReadTimeout = tuning.ReadTimeout
+	server.ReadHeaderTimeout = tuning.ReadHeaderTimeout
+	server.WriteTimeout = tuning.WriteTimeout
+	server.IdleTimeout = tuning.IdleTimeout
+	server.MaxHeaderBytes = tuning.MaxHeaderBytes
+	server.ClientIPHeaders = tuning.ClientIPHeaders
+	server.Protocols = tuning.Protocols
+
+	if ranges := expandTrustedProxies(tuning.TrustedProxies); len(ranges) > 0 {
+		server.TrustedProxies = &TrustedProxies{Source: "static", Ranges: ranges}
+	}
+	if boolValue(tuning.ProxyProtocol) {
+		server.ListenerWrappers = []ListenerWrapper{
+			{Wrapper: "proxy_protocol", Allow: expandTrustedProxies(tuning.ProxyProtocolAllow)},
+			{Wrapper: "tls"},
+		}
+	}
+	if boolValue(tuning.StrictSNIHost) {
+		server.StrictSNIHost = tuning.StrictSNIHost
+	}
+}
+
+// ValidateServerTuning checks durations, header names, proxy ranges and protocols
+func ValidateServerTuning(tuning ServerTuning) error {
+	timeouts := []struct{ option, value string }{
+		{"read_timeout", tuning.ReadTimeout},
+		{"read_header_timeout", tuning.ReadHeaderTimeout},
+		{"write_timeout", tuning.WriteTimeout},
+		{"idle_timeout", tuning.IdleTimeout},
+	}
+	for _, timeout := range timeouts {
+		if timeout.value == "" {
+			continue
+		}
+		if _, err := parseDuration(timeout.value); err != nil {
+			return fmt.Errorf("%s: %w", timeout.option, err)
+		}
+	}
+	if tuning.MaxHeaderBytes < 0 {
+		return fmt.Errorf("max_header_bytes cannot be negative")
+	}
+	if tuning.MaxHeaderBytes > 0 && tuning.MaxHeaderBytes < 1024 {
+		return fmt.Errorf("max_header_bytes must be at least 1024")
+	}
+
+	for _, value := range tuning.TrustedProxies {
+		if _, ok := trustedProxyPresets[strings.ToLower(value)]; ok {
+			continue
+		}
+		if err := ValidateIPOrCIDR(value); err != nil {
+			return fmt.Errorf("trusted_proxies: %w", err)
+		}
+	}
+	for _, name := range tuning.ClientIPHeaders {
+		if name == "" || strings.ContainsAny(name, " ,:") {
+			return fmt.Errorf("invalid client IP header %q", name)
+		}
+	}
+
+	for _, protocol := range tuning.Protocols {
+		if !supportedServerProtocols[protocol] {
+			return fmt.Errorf("unsupported protocol %q: must be h1, h2, h2c or h3", protocol)
+		}
+	}
+
+	if boolValue(tuning.ProxyProtocol) {
+		if len(tuning.ProxyProtocolAllow) == 0 {
+			return fmt.Errorf("proxy_protocol requires proxy_protocol_allow, or any client could spoof its address")
+		}
+		for _, value := range tuning.ProxyProtocolAllow {
+			if _, ok := trustedProxyPresets[strings.ToLower(value)]; ok {
+				continue
+			}
+			if err := ValidateIPOrCIDR(value); err != nil {
+				return fmt.Errorf("proxy_protocol_allow: %w", err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,615 @@
+package caddy
+
+import (
+	"caddyadmin/models"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ValidateHeaderRule checks that a header rule can be translated into a headers handler
+func ValidateHeaderRule(rule models.HeaderRule) error {
+	switch rule.Direction {
+	case "request", "response":
+	default:
+		return fmt.Errorf("invalid direction %q: must be request or response", rule.Direction)
+	}
+
+	if strings.TrimSpace(rule.HeaderName) == "" {
+		return fmt.Errorf("header name is required")
+	}
+
+	switch rule.Operation {
+	case "set", "add":
+		if rule.HeaderValue == "" {
+			return fmt.Errorf("header value is required for %s", rule.Operation)
+		}
+	case "delete":
+	case "replace":
+		if rule.HeaderValue == "" {
+			return fmt.Errorf("header value (search string) is required for replace")
+		}
+	default:
+		return fmt.Errorf("invalid operation %q: must be set, add, delete or replace", rule.Operation)
+	}
+
+	return nil
+}
+
+// ValidateAccessRule checks the rule type and that the CIDR is a valid IP or CIDR range
+func ValidateAccessRule(rule models.AccessRule) error {
+	if rule.RuleType != "allow" && rule.RuleType != "deny" {
+		return fmt.Errorf("invalid rule type %q: must be allow or deny", rule.RuleType)
+	}
+	return ValidateIPOrCIDR(rule.CIDR)
+}
+
+// ValidateIPOrCIDR checks that value is a bare IP address or a CIDR range
+func ValidateIPOrCIDR(value string) error {
+	if strings.Contains(value, "/") {
+		if _, _, err := net.ParseCIDR(value); err != nil {
+			return fmt.Errorf("invalid CIDR %q", value)
+		}
+		return nil
+	}
+	if net.ParseIP(value) == nil {
+		return fmt.Errorf("invalid IP address %q", value)
+	}
+	return nil
+}
+
+// ParseCompressionTypes splits a comma-separated list of encoders, preserving order
+func ParseCompressionTypes(value string) []string {
+	var types []string
+	for _, t := range strings.Split(value, ",") {
+		if t = strings.ToLower(strings.TrimSpace(t)); t != "" {
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// ValidateCompression checks encoder names and the gzip level of middleware settings
+func ValidateCompression(settings models.MiddlewareSettings) error {
+	types := ParseCompressionTypes(settings.CompressionTypes)
+	if settings.CompressionEnabled && len(types) == 0 {
+		return fmt.Errorf("at least one compression type is required")
+	}
+	for _, t := range types {
+		if t != "gzip" && t != "zstd" {
+			return fmt.Errorf("unsupported compression type %q: must be gzip or zstd", t)
+		}
+	}
+	if settings.CompressionLevel < 0 || settings.CompressionLevel > 9 {
+		return fmt.Errorf("compression level must be between 1 and 9 (0 uses the encoder default)")
+	}
+	if settings.CompressionMinLength < 0 {
+		return fmt.Errorf("compression minimum length cannot be negative")
+	}
+	return nil
+}
+
+// ValidateRewriteRule checks the match type, pattern and capture group references of a rewrite rule
+func ValidateRewriteRule(rule models.RewriteRule) error {
+	if rule.Replacement == "" {
+		return fmt.Errorf("replacement is required")
+	}
+	if rule.StripPrefix != "" && !strings.HasPrefix(rule.StripPrefix, "/") {
+		return fmt.Errorf("strip prefix must start with /")
+	}
+
+	switch rule.MatchType {
+	case "prefix", "exact", "":
+		if !strings.HasPrefix(rule.Pattern, "/") {
+			return fmt.Errorf("pattern must start with /")
+		}
+		if captureGroupPattern.MatchString(rule.Replacement) {
+			return fmt.Errorf("capture group references require match type regexp")
+		}
+	case "regexp":
+		re, err := regexp.Compile(rule.Pattern)
+		if err != nil {
+			return fmt.Errorf("invalid regular expression: %v", err)
+		}
+		for _, ref := range captureGroupPattern.FindAllStringSubmatch(rule.Replacement, -1) {
+			group := strings.Trim(ref[1], "{}")
+			if n, err := strconv.Atoi(group); err == nil {
+				if n > re.NumSubexp() {
+					return fmt.Errorf("replacement references group $%d but pattern has %d capture groups", n, re.NumSubexp())
+				}
+			} else if re.SubexpIndex(group) < 0 {
+				return fmt.Errorf("replacement references unknown named group %q", group)
+			}
+		}
+	default:
+		return fmt.Errorf("invalid match type %q: must be prefix, exact or regexp", rule.MatchType)
+	}
+
+	return nil
+}
+
+// ValidateRouteMatch checks a route's path matcher against its match type
+func ValidateRouteMatch(matchType, pathMatcher string) error {
+	switch matchType {
+	case "path", "path_prefix", "":
+		if pathMatcher != "" && pathMatcher != "*" && !strings.HasPrefix(pathMatcher, "/") {
+			return fmt.Errorf("path matcher must start with /")
+		}
+	case "path_regexp":
+		if _, err := regexp.Compile(pathMatcher); err != nil {
+			return fmt.Errorf("invalid path regular expression: %v", err)
+		}
+	default:
+		return fmt.Errorf("invalid match type %q: must be path, path_prefix or path_regexp", matchType)
+	}
+	return nil
+}
+
+// ValidateRouteMatchers checks header names, regexps, IP ranges, protocol and expression syntax
+func ValidateRouteMatchers(m *models.RouteMatchers) error {
+	if m == nil {
+		return nil
+	}
+
+	for header := range m.Header {
+		if strings.TrimSpace(header) == "" {
+			return fmt.Errorf("header matcher name cannot be empty")
+		}
+	}
+	for header, pattern := range m.HeaderRegexp {
+		if strings.TrimSpace(header) == "" {
+			return fmt.Errorf("header_regexp matcher name cannot be empty")
+		}
+		if _, err := regexp.Compile(pattern); err != nil {
+			return fmt.Errorf("invalid header_regexp for %s: %v", header, err)
+		}
+	}
+	for key := range m.Query {
+		if key == "" {
+			return fmt.Errorf("query matcher key cannot be empty")
+		}
+	}
+	for _, ip := range m.RemoteIP {
+		if err := ValidateIPOrCIDR(ip); err != nil {
+			return fmt.Errorf("remote_ip: %v", err)
+		}
+	}
+	for _, ip := range m.ClientIP {
+		if err := ValidateIPOrCIDR(ip); err != nil {
+			return fmt.Errorf("client_ip: %v", err)
+		}
+	}
+
+	switch m.Protocol {
+	case "", "http", "https", "grpc", "http/1.0", "http/1.1", "http/2", "http/3", "http/1.1+", "http/2+":
+	default:
+		return fmt.Errorf("invalid protocol %q", m.Protocol)
+	}
+
+	if m.Expression != "" {
+		if err := checkExpressionSyntax(m.Expression); err != nil {
+			return fmt.Errorf("invalid expression: %v", err)
+		}
+	}
+
+	if m.Not != nil {
+		if m.Not.Not != nil {
+			return fmt.Errorf("nested not matchers are not supported")
+		}
+		if err := ValidateRouteMatchers(m.Not); err != nil {
+			return fmt.Errorf("not: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// ValidateHandlerSteps checks a route pipeline: known handler types, middleware
+// steps first and at most one terminal step, which must come last
+func ValidateHandlerSteps(steps []models.HandlerStep) error {
+	if len(steps) == 0 {
+		return fmt.Errorf("at least one handler is required")
+	}
+
+	for i, step := range steps {
+		switch step.Type {
+		case "encode", "headers", "rewrite", "authentication":
+		case "forward_auth":
+			if err := ValidateForwardAuth(forwardAuthFromStep(step.Config)); err != nil {
+				return fmt.Errorf("handler %d (forward_auth): %w", i+1, err)
+			}
+		case "cors":
+			if err := ValidateCORSPolicy(corsFromStep(step.Config)); err != nil {
+				return fmt.Errorf("handler %d (cors): %w", i+1, err)
+			}
+		case "reverse_proxy", "file_server", "static_response", "redirect", "php_fastcgi":
+			if i != len(steps)-1 {
+				return fmt.Errorf("handler %d (%s) is terminal and must be the last step", i+1, step.Type)
+			}
+			var err error
+			switch step.Type {
+			case "php_fastcgi":
+				_, err = parsePHPFastCGIConfig(step.Config)
+			case "file_server":
+				_, err = parseFileServerConfig(step.Config)
+			case "reverse_proxy":
+				_, err = parseProxyOptions(step.Config)
+			}
+			if err != nil {
+				return fmt.Errorf("handler %d (%s): %w", i+1, step.Type, err)
+			}
+		case "":
+			return fmt.Errorf("handler %d is missing a type", i+1)
+		default:
+			return fmt.Errorf("handler %d has unknown type %q", i+1, step.Type)
+		}
+	}
+	return nil
+}
+
+// ValidateUpstream checks an upstream's scheme and backend TLS settings
+func ValidateUpstream(u models.Upstream) error {
+	switch u.Scheme {
+	case "http", "h2c", "":
+		if u.TLSServerName != "" || u.TLSInsecureSkipVerify || u.TLSRootCAID != "" || u.TLSClientCertPath != "" {
+			return fmt.Errorf("TLS settings require scheme https")
+		}
+	case "https":
+		if (u.TLSClientCertPath == "") != (u.TLSClientKeyPath == "") {
+			return fmt.Errorf("client certificate and key must be set together")
+		}
+	default:
+		return fmt.Errorf("invalid scheme %q: must be http, https or h2c", u.Scheme)
+	}
+	if u.Weight < 0 || u.MaxRequests < 0 || u.MaxConnections < 0 {
+		return fmt.Errorf("weight, max requests and max connections cannot be negative")
+	}
+	if u.HealthCheckPath != "" && !strings.HasPrefix(u.HealthCheckPath, "/") {
+		return fmt.Errorf("health check path must start with /")
+	}
+	if strings.Contains(u.Address, "://") {
+		return fmt.Errorf("address must not include a scheme; use the scheme field instead")
+	}
+	return nil
+}
+
+// ValidateUpstreamTransport checks that upstreams proxied together can share one transport:
+// Caddy configures the transport per handler, so schemes and TLS settings must match
+func ValidateUpstreamTransport(upstreams []models.Upstream) error {
+	if len(upstreams) == 0 {
+		return nil
+	}
+
+	scheme := func(u models.Upstream) string {
+		if u.Scheme == "" {
+			return "http"
+		}
+		return u.Scheme
+	}
+
+	first := upstreams[0]
+	for _, u := range upstreams[1:] {
+		if scheme(u) != scheme(first) {
+			return fmt.Errorf("upstreams %s (%s) and %s (%s) use different schemes; all upstreams in a group must use the same scheme",
+				upstreamLabel(first), scheme(first), upstreamLabel(u), scheme(u))
+		}
+		if u.TLSServerName != first.TLSServerName || u.TLSInsecureSkipVerify != first.TLSInsecureSkipVerify ||
+			u.TLSRootCAID != first.TLSRootCAID || u.TLSClientCertPath != first.TLSClientCertPath ||
+			u.TLSClientKeyPath != first.TLSClientKeyPath {
+			return fmt.Errorf("upstreams %s and %s use different TLS settings; all upstreams in a group must share them",
+				upstreamLabel(first), upstreamLabel(u))
+		}
+	}
+	return nil
+}
+
+// ValidateGroupHealthCheckPaths checks that active health checks of a group have one path to
+// probe: Caddy checks all upstreams of a handler with the same URI, so upstreams with differing
+// paths need a group health check path
+func ValidateGroupHealthCheckPaths(group models.UpstreamGroup, upstreams []models.Upstream) error {
+	if !group.HealthChecks || group.HealthCheckPath != "" {
+		return nil
+	}
+	if paths := upstreamHealthCheckPaths(upstreams); len(paths) > 1 {
+		return fmt.Errorf("upstreams use different health check paths (%s); set a health check path on the group",
+			strings.Join(paths, ", "))
+	}
+	return nil
+}
+
+// upstreamHealthCheckPaths returns the distinct health check paths set on the upstreams, in order
+func upstreamHealthCheckPaths(upstreams []models.Upstream) []string {
+	var paths []string
+	seen := make(map[string]bool)
+	for _, u := range upstreams {
+		if u.HealthCheckPath != "" && !seen[u.HealthCheckPath] {
+			seen[u.HealthCheckPath] = true
+			paths = append(paths, u.HealthCheckPath)
+		}
+	}
+	return paths
+}
+
+func upstreamLabel(u models.Upstream) string {
+	if u.Name != "" {
+		return u.Name
+	}
+	return u.Address
+}
+
+// ValidateUpstreamGroup checks the load balancing and health check settings of an upstream group
+func ValidateUpstreamGroup(group models.UpstreamGroup) error {
+	switch group.LoadBalancing {
+	case "", "round_robin", "weighted_round_robin", "least_conn", "first", "random", "random_choose",
+		"ip_hash", "client_ip_hash", "uri_hash", "cookie":
+	case "header":
+		if strings.TrimSpace(group.LoadBalancingHeader) == "" {
+			return fmt.Errorf("header load balancing requires a header field")
+		}
+	case "query":
+		if strings.TrimSpace(group.LoadBalancingQueryKey) == "" {
+			return fmt.Errorf("query load balancing requires a query key")
+		}
+	default:
+		return fmt.Errorf("invalid load balancing policy %q", group.LoadBalancing)
+	}
+	if group.Retries < 0 || group.TryDuration < 0 || group.TryInterval < 0 {
+		return fmt.Errorf("retries, try duration and try interval cannot be negative")
+	}
+
+	if group.HealthCheckPath != "" && !strings.HasPrefix(group.HealthCheckPath, "/") {
+		return fmt.Errorf("health check path must start with /")
+	}
+	if group.HealthCheckTimeout < 0 {
+		return fmt.Errorf("health check timeout cannot be negative")
+	}
+	if group.HealthCheckExpectStatus != 0 && (group.HealthCheckExpectStatus < 100 || group.HealthCheckExpectStatus > 599) {
+		return fmt.Errorf("invalid expected status %d", group.HealthCheckExpectStatus)
+	}
+	for name := range group.HealthCheckHeaders {
+		if strings.TrimSpace(name) == "" {
+			return fmt.Errorf("health check header name cannot be empty")
+		}
+	}
+
+	if group.PassiveFailDuration < 0 || group.PassiveMaxFails < 0 || group.PassiveUnhealthyLatency < 0 {
+		return fmt.Errorf("passive health check values cannot be negative")
+	}
+	for _, status := range group.PassiveUnhealthyStatus {
+		if status < 100 || status > 599 {
+			return fmt.Errorf("invalid unhealthy status %d", status)
+		}
+	}
+	return nil
+}
+
+// supportedCurves are the elliptic curve names accepted in connection policies
+var supportedCurves = map[string]bool{
+	"x25519":         true,
+	"x25519mlkem768": true,
+	"secp256r1":      true,
+	"secp384r1":      true,
+	"secp521r1":      true,
+}
+
+// supportedALPN are the application protocols Caddy's HTTP server can negotiate
+var supportedALPN = map[string]bool{
+	"h3":       true,
+	"h2":       true,
+	"http/1.1": true,
+}
+
+func isSupportedTLSVersion(version string) bool {
+	return version == "tls1.2" || version == "tls1.3"
+}
+
+// ValidateTLSProtocols checks the minimum version, cipher suites, curves and ALPN
+// values of a connection policy. Lists may be JSON arrays or comma-separated.
+func ValidateTLSProtocols(minVersion, cipherSuites, curves, alpn string) error {
+	if minVersion != "" && !isSupportedTLSVersion(minVersion) {
+		return fmt.Errorf("unsupported minimum TLS version %q: must be tls1.2 or tls1.3", minVersion)
+	}
+
+	// Caddy accepts the secure suites of crypto/tls; TLS 1.3 suites are not configurable
+	suites := make(map[string]bool)
+	for _, suite := range tls.CipherSuites() {
+		for _, v := range suite.SupportedVersions {
+			if v != tls.VersionTLS13 {
+				suites[suite.Name] = true
+			}
+		}
+	}
+	for _, name := range ParseStringList(cipherSuites) {
+		if !suites[name] {
+			return fmt.Errorf("unsupported cipher suite %q", name)
+		}
+	}
+
+	for _, name := range ParseStringList(curves) {
+		if !supportedCurves[name] {
+			return fmt.Errorf("unsupported curve %q", name)
+		}
+	}
+	for _, name := range ParseStringList(alpn) {
+		if !supportedALPN[name] {
+			return fmt.Errorf("unsupported ALPN protocol %q: must be h3, h2 or http/1.1", name)
+		}
+	}
+	return nil
+}
+
+// ValidateACMESettings checks the provider, directory URL, EAB pair and key type of an ACME issuer
+func ValidateACMESettings(provider, directory, eabKeyID, eabMACKey, keyType string) error {
+	switch provider {
+	case "", "letsencrypt", "zerossl", "buypass":
+	case "custom":
+		if directory == "" {
+			return fmt.Errorf("ACME provider custom requires a directory URL")
+		}
+	default:
+		return fmt.Errorf("invalid ACME provider %q: must be letsencrypt, zerossl, buypass or custom", provider)
+	}
+
+	if directory != "" {
+		u, err := url.Parse(directory)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("invalid ACME directory URL %q", directory)
+		}
+	}
+
+	if (eabKeyID == "") != (eabMACKey == "") {
+		return fmt.Errorf("EAB key ID and MAC key must be set together")
+	}
+
+	switch keyType {
+	case "", "ed25519", "p256", "p384", "rsa2048", "rsa4096":
+	default:
+		return fmt.Errorf("invalid key type %q: must be ed25519, p256, p384, rsa2048 or rsa4096", keyType)
+	}
+	return nil
+}
+
+// ValidateSecurityHeaders checks the preset and CSP directive names of a site's security headers
+func ValidateSecurityHeaders(settings models.MiddlewareSettings) error {
+	if _, ok := securityPresets[settings.SecurityPreset]; !ok && settings.SecurityPreset != "" && settings.SecurityPreset != "none" {
+		return fmt.Errorf("invalid security preset %q: must be none, basic or strict", settings.SecurityPreset)
+	}
+	if v := settings.XFrameOptions; v != "" && v != "off" && v != "DENY" && v != "SAMEORIGIN" {
+		return fmt.Errorf("invalid X-Frame-Options %q: must be DENY, SAMEORIGIN or off", v)
+	}
+	for name, sources := range settings.CSPDirectives {
+		if name == "" || strings.ContainsAny(name, " ;") {
+			return fmt.Errorf("invalid CSP directive %q", name)
+		}
+		for _, source := range sources {
+			if strings.ContainsAny(source, ";,") {
+				return fmt.Errorf("invalid source %q in CSP directive %s", source, name)
+			}
+		}
+	}
+	return nil
+}
+
+// ValidateErrorPage checks an error page's status codes and the settings its action needs
+func ValidateErrorPage(page models.ErrorPage) error {
+	if _, err := parseStatusCodes(page.StatusCodes); err != nil {
+		return err
+	}
+
+	switch page.Action {
+	case "file", "":
+		if page.FilePath == "" {
+			return fmt.Errorf("file path is required")
+		}
+		for _, part := range strings.Split(page.FilePath, "/") {
+			if part == ".." {
+				return fmt.Errorf("file path must stay inside the site's public directory")
+			}
+		}
+	case "static":
+		if page.Body == "" {
+			return fmt.Errorf("body is required")
+		}
+	case "redirect":
+		if page.Location == "" {
+			return fmt.Errorf("location is required")
+		}
+		switch page.RedirectCode {
+		case 0, 301, 302, 303, 307, 308:
+		default:
+			return fmt.Errorf("invalid redirect code %d: must be 301, 302, 303, 307 or 308", page.RedirectCode)
+		}
+	default:
+		return fmt.Errorf("invalid action %q: must be file, static or redirect", page.Action)
+	}
+	return nil
+}
+
+// ValidateForwardAuth checks the provider, auth service address, verification URI and copied headers
+func ValidateForwardAuth(fa ForwardAuthConfig) error {
+	if _, ok := forwardAuthProviders[fa.Provider]; !ok && fa.Provider != "" {
+		return fmt.Errorf("invalid forward auth provider %q: must be authelia, authentik, oauth2-proxy or custom", fa.Provider)
+	}
+	if fa.Upstream == "" {
+		return fmt.Errorf("forward auth upstream is required")
+	}
+	upstream := fa.withDefaults().upstream()
+	if upstream.Scheme == "h2c" {
+		return fmt.Errorf("forward auth upstream must use http or https")
+	}
+	if err := ValidateUpstream(upstream); err != nil {
+		return fmt.Errorf("forward auth upstream: %w", err)
+	}
+	if uri := fa.withDefaults().URI; !strings.HasPrefix(uri, "/") {
+		return fmt.Errorf("forward auth URI is required and must start with /")
+	}
+	for _, name := range fa.CopyHeaders {
+		if name == "" || strings.ContainsAny(name, " :{}") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	return nil
+}
+
+// ValidateCORSPolicy checks origins, methods and header names of a CORS policy
+func ValidateCORSPolicy(policy CORSPolicy) error {
+	if len(policy.AllowedOrigins) == 0 {
+		return fmt.Errorf("at least one allowed origin is required")
+	}
+	for _, origin := range policy.AllowedOrigins {
+		if origin == "*" {
+			if policy.AllowCredentials {
+				return fmt.Errorf("allowed origin * cannot be combined with credentials; list the origins instead")
+			}
+			continue
+		}
+		if !corsOriginPattern.MatchString(origin) {
+			return fmt.Errorf("invalid origin %q: use scheme://host[:port], optionally with a leading *. label", origin)
+		}
+	}
+	for _, method := range policy.AllowedMethods {
+		if method == "" || strings.ToUpper(method) != method || strings.ContainsAny(method, " ,") {
+			return fmt.Errorf("invalid method %q: use upper-case names such as GET", method)
+		}
+	}
+	for _, name := range append(append([]string{}, policy.AllowedHeaders...), policy.ExposedHeaders...) {
+		if name == "" || strings.ContainsAny(name, " ,:") {
+			return fmt.Errorf("invalid header name %q", name)
+		}
+	}
+	if policy.MaxAge < 0 {
+		return fmt.Errorf("max age cannot be negative")
+	}
+	return nil
+}
+
+// ValidateMaintenance checks a site's maintenance page settings and bypass list
+func ValidateMaintenance(site models.Site) error {
+	if site.MaintenanceRetryAfter < 0 {
+		return fmt.Errorf("maintenance retry after cannot be negative")
+	}
+	for _, ip := range site.MaintenanceBypassIPs {
+		if err := ValidateIPOrCIDR(ip); err != nil {
+			return fmt.Errorf("maintenance bypass: %w", err)
+		}
+	}
+	if site.MaintenanceBypassSecret != "" && !maintenanceSecretPattern.MatchString(site.MaintenanceBypassSecret) {
+		return fmt.Errorf("maintenance bypass secret must be 16-128 letters, digits, dashes or underscores")
+	}
+	return nil
+}
+
+// ValidateMaintenanceWindow checks that a maintenance window ends after it starts
+func ValidateMaintenanceWindow(window models.MaintenanceWindow) error {
+	if window.StartsAt.IsZero() || window.EndsAt.IsZero() {
+		return fmt.Errorf("starts_at and ends_at are required")
+	}
+	if !window.EndsAt.After(window.StartsAt) {
+		return fmt.Errorf("ends_at must be after starts_at")
+	}
+	return nil
+}
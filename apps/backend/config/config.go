@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strconv"
+	"strings"
 
 	"github.com/joho/godotenv"
 )
@@ -22,6 +23,9 @@ type Config struct {
 	JWTSecret         string
 	SessionDuration   int  // hours
 	CookieSecure      bool // true for HTTPS, false for HTTP
+	// Extra IPs/CIDRs allowed to call the on-demand TLS ask endpoint besides loopback,
+	// e.g. the Caddy container's network when Caddy runs separately
+	OnDemandAskAllowed []string
 }
 
 // Load creates a new Config with environment variables or defaults
@@ -56,6 +60,12 @@ func Load() *Config {
 		CookieSecure:      cookieSecure,
 	}
 
+	for _, entry := range strings.Split(getEnv("ON_DEMAND_ASK_ALLOWED", ""), ",") {
+		if entry = strings.TrimSpace(entry); entry != "" {
+			cfg.OnDemandAskAllowed = append(cfg.OnDemandAskAllowed, entry)
+		}
+	}
+
 	// Log loaded configuration (mask sensitive data)
 	log.Println("Configuration loaded:")
 	log.Printf("  - CADDY_API_URL: %s", cfg.CaddyAPIURL)
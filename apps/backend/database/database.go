@@ -28,6 +28,7 @@ func Initialize(dbPath string) error {
 		&models.Upstream{},
 		&models.UpstreamGroup{},
 		&models.TLSConfig{},
+		&models.ServerSettings{},
 		&models.ConfigHistory{},
 		&models.GlobalSettings{},
 		&models.BasicAuthUser{},
@@ -35,6 +36,8 @@ func Initialize(dbPath string) error {
 		&models.AccessRule{},
 		&models.RewriteRule{},
 		&models.RedirectRule{},
+		&models.ErrorPage{},
+		&models.MaintenanceWindow{},
 		&models.MiddlewareSettings{},
 		&models.AdminUser{},
 		&models.APIKey{},
@@ -45,10 +48,39 @@ func Initialize(dbPath string) error {
 		return err
 	}
 
+	if err := migrateRouteHandlers(); err != nil {
+		return err
+	}
+
 	log.Println("Database initialized")
 	return nil
 }
 
+// migrateRouteHandlers converts legacy single-handler routes into handler pipelines
+func migrateRouteHandlers() error {
+	var routes []models.Route
+	if err := DB.Where("handlers IS NULL OR handlers = ''").Find(&routes).Error; err != nil {
+		return err
+	}
+
+	for _, route := range routes {
+		steps, err := route.HandlerSteps()
+		if err != nil {
+			log.Printf("Warning: skipping handler migration for route %s: %v", route.ID, err)
+			continue
+		}
+		route.SetHandlerSteps(steps)
+		if err := DB.Model(&route).Update("handlers", route.HandlersJSON).Error; err != nil {
+			return err
+		}
+	}
+
+	if len(routes) > 0 {
+		log.Printf("Migrated %d routes to handler pipelines", len(routes))
+	}
+	return nil
+}
+
 // GetDB returns the database instance
 func GetDB() *gorm.DB {
 	return DB
@@ -10,6 +10,7 @@ import (
 	"path/filepath"
 	"time"
 
+	"caddyadmin/caddy"
 	"caddyadmin/database"
 	"caddyadmin/models"
 
@@ -33,12 +34,15 @@ type BackupData struct {
 	Upstreams  []models.Upstream         `json:"upstreams"`
 	Groups     []models.UpstreamGroup    `json:"upstream_groups"`
 	TLS        []models.TLSConfig        `json:"tls_configs"`
+	Server     []models.ServerSettings   `json:"server_settings"`
 	Settings   *models.GlobalSettings    `json:"global_settings"`
 	Middleware []models.MiddlewareSettings `json:"middleware_settings"`
 	AuthUsers  []models.BasicAuthUser    `json:"basic_auth_users"`
 	Headers    []models.HeaderRule       `json:"header_rules"`
 	Access     []models.AccessRule       `json:"access_rules"`
 	Rewrites   []models.RewriteRule      `json:"rewrite_rules"`
+	ErrorPages []models.ErrorPage        `json:"error_pages"`
+	Maintenance []models.MaintenanceWindow `json:"maintenance_windows"`
 }
 
 // CreateBackup exports all configuration as JSON
@@ -49,16 +53,19 @@ func (h *BackupHandler) CreateBackup(c *gin.Context) {
 	}
 
 	// Load all data
-	database.DB.Find(&backup.Sites)
+	backup.Sites, _ = loadSitesWithHosts()
 	database.DB.Find(&backup.Routes)
 	database.DB.Find(&backup.Upstreams)
 	database.DB.Preload("Upstreams").Find(&backup.Groups)
 	database.DB.Find(&backup.TLS)
+	database.DB.Find(&backup.Server)
 	database.DB.Find(&backup.Middleware)
 	database.DB.Find(&backup.AuthUsers)
 	database.DB.Find(&backup.Headers)
 	database.DB.Find(&backup.Access)
 	database.DB.Find(&backup.Rewrites)
+	database.DB.Find(&backup.ErrorPages)
+	database.DB.Find(&backup.Maintenance)
 
 	var settings models.GlobalSettings
 	if err := database.DB.First(&settings).Error; err == nil {
@@ -81,11 +88,14 @@ func (h *BackupHandler) RestoreBackup(c *gin.Context) {
 	tx := database.DB.Begin()
 
 	// Clear existing data (except admin users and API keys)
+	tx.Exec("DELETE FROM maintenance_windows")
+	tx.Exec("DELETE FROM error_pages")
 	tx.Exec("DELETE FROM rewrite_rules")
 	tx.Exec("DELETE FROM access_rules")
 	tx.Exec("DELETE FROM header_rules")
 	tx.Exec("DELETE FROM basic_auth_users")
 	tx.Exec("DELETE FROM middleware_settings")
+	tx.Exec("DELETE FROM server_settings")
 	tx.Exec("DELETE FROM tls_configs")
 	tx.Exec("DELETE FROM upstream_group_members")
 	tx.Exec("DELETE FROM upstream_groups")
@@ -95,6 +105,10 @@ func (h *BackupHandler) RestoreBackup(c *gin.Context) {
 
 	// Restore data
 	for _, site := range backup.Sites {
+		if site.Hosts != nil {
+			hostsJSON, _ := json.Marshal(site.Hosts)
+			site.HostsJSON = string(hostsJSON)
+		}
 		if err := tx.Create(&site).Error; err != nil {
 			tx.Rollback()
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to restore sites: " + err.Error()})
@@ -118,6 +132,10 @@ func (h *BackupHandler) RestoreBackup(c *gin.Context) {
 		tx.Create(&tls)
 	}
 
+	for _, server := range backup.Server {
+		tx.Create(&server)
+	}
+
 	for _, middleware := range backup.Middleware {
 		tx.Create(&middleware)
 	}
@@ -138,6 +156,14 @@ func (h *BackupHandler) RestoreBackup(c *gin.Context) {
 		tx.Create(&rewrite)
 	}
 
+	for _, page := range backup.ErrorPages {
+		tx.Create(&page)
+	}
+
+	for _, window := range backup.Maintenance {
+		tx.Create(&window)
+	}
+
 	if backup.Settings != nil {
 		tx.Where("1=1").Delete(&models.GlobalSettings{})
 		tx.Create(backup.Settings)
@@ -145,7 +171,7 @@ func (h *BackupHandler) RestoreBackup(c *gin.Context) {
 
 	tx.Commit()
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message": "Backup restored successfully",
 		"stats": gin.H{
 			"sites":     len(backup.Sites),
@@ -153,7 +179,14 @@ func (h *BackupHandler) RestoreBackup(c *gin.Context) {
 			"upstreams": len(backup.Upstreams),
 			"groups":    len(backup.Groups),
 		},
-	})
+	}
+	// Report hosts the backup assigns to more than one site on a port
+	if sites, err := loadSitesWithHosts(); err == nil {
+		if conflicts := caddy.FindHostConflicts(sites); len(conflicts) > 0 {
+			response["conflicts"] = conflicts
+		}
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // DownloadBackupFile creates a downloadable ZIP backup
@@ -163,16 +196,19 @@ func (h *BackupHandler) DownloadBackupFile(c *gin.Context) {
 		Timestamp: time.Now(),
 	}
 
-	database.DB.Find(&backup.Sites)
+	backup.Sites, _ = loadSitesWithHosts()
 	database.DB.Find(&backup.Routes)
 	database.DB.Find(&backup.Upstreams)
 	database.DB.Preload("Upstreams").Find(&backup.Groups)
 	database.DB.Find(&backup.TLS)
+	database.DB.Find(&backup.Server)
 	database.DB.Find(&backup.Middleware)
 	database.DB.Find(&backup.AuthUsers)
 	database.DB.Find(&backup.Headers)
 	database.DB.Find(&backup.Access)
 	database.DB.Find(&backup.Rewrites)
+	database.DB.Find(&backup.ErrorPages)
+	database.DB.Find(&backup.Maintenance)
 
 	var settings models.GlobalSettings
 	if err := database.DB.First(&settings).Error; err == nil {
@@ -160,6 +160,19 @@ func (h *CertificateHandler) DeleteCertificate(c *gin.Context) {
 		return
 	}
 
+	// Refuse to delete a certificate that sites or upstreams still use
+	var bound int64
+	database.GetDB().Model(&models.TLSConfig{}).Where("certificate_id = ?", id).Count(&bound)
+	if bound > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Certificate is bound to %d site(s)", bound)})
+		return
+	}
+	database.GetDB().Model(&models.Upstream{}).Where("tls_root_ca_id = ?", id).Count(&bound)
+	if bound > 0 {
+		c.JSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("Certificate is trusted as root CA by %d upstream(s)", bound)})
+		return
+	}
+
 	// Delete from DB
 	if err := database.GetDB().Delete(&cert).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete certificate record"})
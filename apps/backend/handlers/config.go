@@ -6,6 +6,7 @@ import (
 	"caddyadmin/models"
 	"encoding/json"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
@@ -147,13 +148,25 @@ func (h *ConfigHandler) SyncConfig(c *gin.Context) {
 		ResourceType: "config",
 		NewState:     string(configJSON),
 		Success:      true,
+		Warnings:     strings.Join(config.Warnings, "\n"),
 	}
 	database.GetDB().Create(&history)
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message": "Configuration synchronized successfully",
 		"config":  config,
-	})
+	}
+	// Report what the build skipped; the rest of the config was applied
+	if len(config.Warnings) > 0 {
+		response["warnings"] = config.Warnings
+	}
+	// Report hosts served by only one of the sites claiming them
+	if sites, err := loadSitesWithHosts(); err == nil {
+		if conflicts := caddy.FindHostConflicts(sites); len(conflicts) > 0 {
+			response["conflicts"] = conflicts
+		}
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // AdaptConfig adapts a configuration format to JSON
@@ -229,6 +242,23 @@ func (h *ConfigHandler) UpdateGlobalSettings(c *gin.Context) {
 		return
 	}
 
+	if err := caddy.ValidateTLSProtocols(settings.DefaultMinTLS, settings.DefaultCipherSuites, settings.DefaultCurves, settings.DefaultALPN); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := caddy.ValidateACMESettings(settings.DefaultACMEProvider, settings.DefaultACMEDirectory, settings.DefaultACMEEABKeyID, settings.DefaultACMEEABMACKey, settings.DefaultKeyType); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := caddy.ValidateIssuer(nil, &settings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := caddy.ValidateServerTuning(caddy.GlobalServerTuning(&settings)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	var result error
 	if settings.ID == "" {
 		result = database.GetDB().Create(&settings).Error
@@ -252,5 +282,10 @@ func (h *ConfigHandler) UpdateGlobalSettings(c *gin.Context) {
 	}
 	database.GetDB().Create(&history)
 
+	// Sync to Caddy
+	if config, err := h.configBuilder.BuildFromDB(); err == nil {
+		h.configBuilder.ApplyConfig(config)
+	}
+
 	c.JSON(http.StatusOK, settings)
 }
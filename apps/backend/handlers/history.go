@@ -138,6 +138,18 @@ func (h *HistoryHandler) RollbackToHistory(c *gin.Context) {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid site data in history"})
 				return
 			}
+			if site.Hosts != nil {
+				hostsJSON, _ := json.Marshal(site.Hosts)
+				site.HostsJSON = string(hostsJSON)
+			}
+			// Refuse to bring back hosts another site has claimed since
+			if conflicts := siteHostConflicts(site); len(conflicts) > 0 {
+				c.JSON(http.StatusConflict, gin.H{
+					"error":     "Hosts are already claimed by another site on this port",
+					"conflicts": conflicts,
+				})
+				return
+			}
 			// Restore site
 			database.GetDB().Save(&site)
 		}
@@ -149,6 +161,18 @@ func (h *HistoryHandler) RollbackToHistory(c *gin.Context) {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid route data in history"})
 				return
 			}
+			// Re-encode the JSON-backed fields that are only present in the snapshot
+			if route.Methods != nil {
+				methodsJSON, _ := json.Marshal(route.Methods)
+				route.MethodsJSON = string(methodsJSON)
+			}
+			if route.Matchers != nil {
+				matchersJSON, _ := json.Marshal(route.Matchers)
+				route.MatchersJSON = string(matchersJSON)
+			}
+			if len(route.Handlers) > 0 {
+				route.SetHandlerSteps(route.Handlers)
+			}
 			// Restore route
 			database.GetDB().Save(&route)
 		}
@@ -182,10 +206,17 @@ func (h *HistoryHandler) RollbackToHistory(c *gin.Context) {
 		h.syncToCaddy()
 	}
 
-	c.JSON(http.StatusOK, gin.H{
+	response := gin.H{
 		"message": "Rollback completed successfully",
 		"entry":   entry,
-	})
+	}
+	// Report hosts served by only one of the sites claiming them
+	if sites, err := loadSitesWithHosts(); err == nil {
+		if conflicts := caddy.FindHostConflicts(sites); len(conflicts) > 0 {
+			response["conflicts"] = conflicts
+		}
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // CompareHistory compares two history entries
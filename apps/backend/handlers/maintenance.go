@@ -0,0 +1,441 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"caddyadmin/caddy"
+	"caddyadmin/database"
+	"caddyadmin/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// maintenanceCheckInterval is how often the scheduler looks for windows starting or ending
+const maintenanceCheckInterval = 30 * time.Second
+
+// MaintenanceHandler handles maintenance mode endpoints and the window scheduler
+type MaintenanceHandler struct {
+	configBuilder *caddy.ConfigBuilder
+	mu            sync.Mutex // serializes window transitions between the scheduler and requests
+}
+
+// NewMaintenanceHandler creates a new maintenance handler
+func NewMaintenanceHandler(client *caddy.Client) *MaintenanceHandler {
+	return &MaintenanceHandler{
+		configBuilder: caddy.NewConfigBuilder(client),
+	}
+}
+
+// MaintenanceSettings is the maintenance configuration of a site
+type MaintenanceSettings struct {
+	Enabled      bool     `json:"enabled"`
+	RetryAfter   int      `json:"retry_after"`   // seconds, used outside scheduled windows
+	Page         string   `json:"page"`          // HTML body, a built-in page when empty
+	BypassIPs    []string `json:"bypass_ips"`    // IPs or CIDRs served normally
+	BypassSecret string   `json:"bypass_secret"` // testers send it as the bypass cookie
+	BypassCookie string   `json:"bypass_cookie"` // name of the bypass cookie, read-only
+}
+
+// GetMaintenance retrieves the maintenance settings of a site
+// @Summary      Get maintenance settings
+// @Description  Get the maintenance mode, page and bypass list of a specific site
+// @Tags         sites
+// @Param        id   path      string  true  "Site ID"
+// @Success      200  {object}  MaintenanceSettings
+// @Router       /sites/{id}/maintenance [get]
+func (h *MaintenanceHandler) GetMaintenance(c *gin.Context) {
+	var site models.Site
+	if err := database.GetDB().First(&site, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found"})
+		return
+	}
+
+	settings := MaintenanceSettings{
+		Enabled:      site.MaintenanceEnabled,
+		RetryAfter:   site.MaintenanceRetryAfter,
+		Page:         site.MaintenancePage,
+		BypassIPs:    []string{},
+		BypassSecret: site.MaintenanceBypassSecret,
+		BypassCookie: caddy.MaintenanceBypassCookie,
+	}
+	if site.MaintenanceBypassIPsJSON != "" {
+		json.Unmarshal([]byte(site.MaintenanceBypassIPsJSON), &settings.BypassIPs)
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateMaintenance updates the maintenance settings of a site
+// @Summary      Update maintenance settings
+// @Description  Turn maintenance mode on or off and set the page and bypass list of a site
+// @Tags         sites
+// @Param        id        path      string               true  "Site ID"
+// @Param        settings  body      MaintenanceSettings  true  "Maintenance settings"
+// @Success      200       {object}  MaintenanceSettings
+// @Router       /sites/{id}/maintenance [put]
+func (h *MaintenanceHandler) UpdateMaintenance(c *gin.Context) {
+	var site models.Site
+	if err := database.GetDB().First(&site, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found"})
+		return
+	}
+	previousState, _ := json.Marshal(site)
+
+	var req MaintenanceSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	site.MaintenanceEnabled = req.Enabled
+	site.MaintenanceRetryAfter = req.RetryAfter
+	site.MaintenancePage = req.Page
+	site.MaintenanceBypassIPs = req.BypassIPs
+	site.MaintenanceBypassSecret = req.BypassSecret
+	if err := caddy.ValidateMaintenance(site); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	bypassJSON, _ := json.Marshal(req.BypassIPs)
+	site.MaintenanceBypassIPsJSON = string(bypassJSON)
+
+	if err := database.GetDB().Save(&site).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	newState, _ := json.Marshal(site)
+	database.GetDB().Create(&models.ConfigHistory{
+		Action:        "update",
+		ResourceType:  "site",
+		ResourceID:    site.ID,
+		ResourceName:  site.Name,
+		PreviousState: string(previousState),
+		NewState:      string(newState),
+		Success:       true,
+	})
+
+	h.syncToCaddy()
+
+	req.BypassCookie = caddy.MaintenanceBypassCookie
+	c.JSON(http.StatusOK, req)
+}
+
+// ListMaintenanceWindows lists the maintenance windows of a site
+// @Summary      List maintenance windows
+// @Description  List the scheduled, active and completed maintenance windows of a site
+// @Tags         sites
+// @Param        id   path      string  true  "Site ID"
+// @Success      200  {object}  map[string][]models.MaintenanceWindow
+// @Router       /sites/{id}/maintenance/windows [get]
+func (h *MaintenanceHandler) ListMaintenanceWindows(c *gin.Context) {
+	var windows []models.MaintenanceWindow
+	if err := database.GetDB().Where("site_id = ?", c.Param("id")).Order("starts_at DESC").Find(&windows).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"windows": windows})
+}
+
+// CreateMaintenanceWindowRequest is the request body for scheduling a maintenance window
+type CreateMaintenanceWindowRequest struct {
+	StartsAt time.Time `json:"starts_at" binding:"required"` // RFC 3339, e.g. 2024-06-01T22:00:00Z
+	EndsAt   time.Time `json:"ends_at" binding:"required"`
+	Reason   string    `json:"reason"`
+}
+
+// CreateMaintenanceWindow schedules a maintenance window
+// @Summary      Schedule maintenance window
+// @Description  Put a site into maintenance mode between two points in time
+// @Tags         sites
+// @Param        id      path      string                          true  "Site ID"
+// @Param        window  body      CreateMaintenanceWindowRequest  true  "Maintenance window JSON"
+// @Success      201     {object}  models.MaintenanceWindow
+// @Router       /sites/{id}/maintenance/windows [post]
+func (h *MaintenanceHandler) CreateMaintenanceWindow(c *gin.Context) {
+	siteID := c.Param("id")
+
+	var site models.Site
+	if err := database.GetDB().First(&site, "id = ?", siteID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found"})
+		return
+	}
+
+	var req CreateMaintenanceWindowRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	window := models.MaintenanceWindow{
+		SiteID:   siteID,
+		StartsAt: req.StartsAt,
+		EndsAt:   req.EndsAt,
+		Reason:   req.Reason,
+		Status:   "scheduled",
+	}
+	if err := caddy.ValidateMaintenanceWindow(window); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if !window.EndsAt.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ends_at is in the past"})
+		return
+	}
+
+	if err := database.GetDB().Create(&window).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A window that has already started takes effect right away
+	h.applyWindows(time.Now())
+	database.GetDB().First(&window, "id = ?", window.ID)
+
+	c.JSON(http.StatusCreated, window)
+}
+
+// DeleteMaintenanceWindow deletes a maintenance window, ending it when it is running
+// @Summary      Delete maintenance window
+// @Description  Delete a maintenance window by ID; a running window ends immediately
+// @Tags         maintenance-windows
+// @Param        id   path      string  true  "Maintenance window ID"
+// @Success      200  {object}  map[string]string
+// @Router       /maintenance-windows/{id} [delete]
+func (h *MaintenanceHandler) DeleteMaintenanceWindow(c *gin.Context) {
+	var window models.MaintenanceWindow
+	if err := database.GetDB().First(&window, "id = ?", c.Param("id")).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Maintenance window not found"})
+		return
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// End the maintenance this window holds before deleting it, so a failed sync keeps the window
+	if window.Status == "active" && window.HoldsMaintenance {
+		if others := otherActiveWindows(window.SiteID, window.ID); len(others) > 0 {
+			passHold(others)
+		} else if err := h.setMaintenance(map[string]bool{window.SiteID: false}, []string{window.SiteID}); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to end maintenance: " + err.Error()})
+			return
+		}
+	}
+
+	if err := database.GetDB().Delete(&window).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Maintenance window deleted"})
+}
+
+// StartScheduler starts the background loop that turns maintenance mode on when a
+// window starts and off when it ends. Windows missed entirely while the server was
+// down are marked completed without toggling anything.
+func (h *MaintenanceHandler) StartScheduler() {
+	go func() {
+		ticker := time.NewTicker(maintenanceCheckInterval)
+		defer ticker.Stop()
+
+		for {
+			h.applyWindows(time.Now())
+			<-ticker.C
+		}
+	}()
+}
+
+// applyWindows moves due windows along scheduled -> active -> completed and toggles their sites.
+// Statuses only advance once Caddy runs the new config, so a failed sync is retried on the next tick.
+func (h *MaintenanceHandler) applyWindows(now time.Time) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	db := database.GetDB()
+
+	var windows []models.MaintenanceWindow
+	if err := db.Where("status IN ? AND starts_at <= ?", []string{"scheduled", "active"}, now).Order("starts_at ASC").Find(&windows).Error; err != nil {
+		log.Printf("Maintenance scheduler: failed to load windows: %v", err)
+		return
+	}
+
+	changes := make(map[string]bool)
+	var order []string
+	toggle := func(siteID string, enabled bool) {
+		if _, seen := changes[siteID]; !seen {
+			order = append(order, siteID)
+		}
+		changes[siteID] = enabled
+	}
+
+	// Ended windows first, so back-to-back windows hand over without a gap
+	var ended []models.MaintenanceWindow
+	var endedIDs []string
+	for _, window := range windows {
+		if !now.Before(window.EndsAt) {
+			ended = append(ended, window)
+			endedIDs = append(endedIDs, window.ID)
+		}
+	}
+	released := make(map[string]bool) // sites whose maintenance an ended window held
+	var heirs []models.MaintenanceWindow
+	for _, window := range ended {
+		if window.Status != "active" || !window.HoldsMaintenance || released[window.SiteID] {
+			continue
+		}
+		released[window.SiteID] = true
+		if others := otherActiveWindows(window.SiteID, endedIDs...); len(others) > 0 {
+			heirs = append(heirs, others...)
+		} else {
+			toggle(window.SiteID, false)
+		}
+	}
+
+	// A starting window holds maintenance unless the site was already in maintenance
+	// that no window holds, i.e. someone switched it on by hand
+	var started []models.MaintenanceWindow
+	for _, window := range windows {
+		if !now.Before(window.EndsAt) || window.Status != "scheduled" {
+			continue
+		}
+		var site models.Site
+		if err := db.First(&site, "id = ?", window.SiteID).Error; err != nil {
+			continue
+		}
+		_, changing := changes[window.SiteID]
+		window.HoldsMaintenance = changing || released[window.SiteID] || !site.MaintenanceEnabled ||
+			len(holdingWindows(otherActiveWindows(window.SiteID, endedIDs...))) > 0
+		if window.HoldsMaintenance {
+			toggle(window.SiteID, true)
+		}
+		started = append(started, window)
+	}
+
+	if len(order) > 0 {
+		if err := h.setMaintenance(changes, order); err != nil {
+			log.Printf("Maintenance scheduler: failed to sync Caddy, retrying on the next tick: %v", err)
+			return
+		}
+	}
+
+	for i := range ended {
+		db.Model(&ended[i]).Update("status", "completed")
+	}
+	passHold(heirs)
+	for i := range started {
+		db.Model(&started[i]).Updates(map[string]interface{}{"status": "active", "holds_maintenance": started[i].HoldsMaintenance})
+	}
+}
+
+// setMaintenance switches maintenance mode of the given sites, re-syncs Caddy once and
+// records a history entry per site that actually changed. When the sync fails the sites
+// are switched back and the error returned, so the caller can retry.
+func (h *MaintenanceHandler) setMaintenance(changes map[string]bool, order []string) error {
+	db := database.GetDB()
+
+	type siteChange struct {
+		site          models.Site
+		previousState []byte
+	}
+	var changed []siteChange
+	for _, siteID := range order {
+		var site models.Site
+		if err := db.First(&site, "id = ?", siteID).Error; err != nil {
+			continue
+		}
+		if site.MaintenanceEnabled == changes[siteID] {
+			continue
+		}
+		previousState, _ := json.Marshal(site)
+		site.MaintenanceEnabled = changes[siteID]
+		if err := db.Model(&site).Update("maintenance_enabled", site.MaintenanceEnabled).Error; err != nil {
+			log.Printf("Maintenance scheduler: failed to update site %s: %v", site.Name, err)
+			continue
+		}
+		changed = append(changed, siteChange{site, previousState})
+	}
+	if len(changed) == 0 {
+		return nil
+	}
+
+	config, err := h.configBuilder.BuildFromDB()
+	if err == nil {
+		err = h.configBuilder.ApplyConfig(config)
+	}
+	if err != nil {
+		for _, change := range changed {
+			db.Model(&change.site).Update("maintenance_enabled", !change.site.MaintenanceEnabled)
+		}
+		return err
+	}
+
+	configJSON, _ := json.Marshal(config)
+	for _, change := range changed {
+		newState, _ := json.Marshal(change.site)
+		db.Create(&models.ConfigHistory{
+			Action:        "update",
+			ResourceType:  "site",
+			ResourceID:    change.site.ID,
+			ResourceName:  change.site.Name,
+			PreviousState: string(change.previousState),
+			NewState:      string(newState),
+			CaddyConfig:   string(configJSON),
+			UserAgent:     "maintenance-scheduler",
+			Success:       true,
+			Warnings:      strings.Join(config.Warnings, "\n"),
+		})
+
+		state := "off"
+		if change.site.MaintenanceEnabled {
+			state = "on"
+		}
+		log.Printf("Maintenance mode %s for site %s", state, change.site.Name)
+	}
+	return nil
+}
+
+// otherActiveWindows returns the running windows of a site, leaving out the given ones
+func otherActiveWindows(siteID string, except ...string) []models.MaintenanceWindow {
+	query := database.GetDB().Where("site_id = ? AND status = ?", siteID, "active")
+	if len(except) > 0 {
+		query = query.Where("id NOT IN ?", except)
+	}
+	var windows []models.MaintenanceWindow
+	query.Find(&windows)
+	return windows
+}
+
+// holdingWindows returns the windows that hold their site's maintenance
+func holdingWindows(windows []models.MaintenanceWindow) []models.MaintenanceWindow {
+	var holding []models.MaintenanceWindow
+	for _, window := range windows {
+		if window.HoldsMaintenance {
+			holding = append(holding, window)
+		}
+	}
+	return holding
+}
+
+// passHold hands the maintenance held by an ending window to the windows still running,
+// so the last of them switches it off
+func passHold(windows []models.MaintenanceWindow) {
+	for i := range windows {
+		database.GetDB().Model(&windows[i]).Update("holds_maintenance", true)
+	}
+}
+
+// syncToCaddy rebuilds and applies configuration to Caddy
+func (h *MaintenanceHandler) syncToCaddy() error {
+	config, err := h.configBuilder.BuildFromDB()
+	if err != nil {
+		return err
+	}
+
+	return h.configBuilder.ApplyConfig(config)
+}
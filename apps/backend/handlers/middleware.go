@@ -1,8 +1,13 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
 
+	"caddyadmin/caddy"
 	"caddyadmin/database"
 	"caddyadmin/models"
 
@@ -11,11 +16,15 @@ import (
 )
 
 // MiddlewareHandler handles middleware configuration endpoints
-type MiddlewareHandler struct{}
+type MiddlewareHandler struct {
+	configBuilder *caddy.ConfigBuilder
+}
 
 // NewMiddlewareHandler creates a new middleware handler
-func NewMiddlewareHandler() *MiddlewareHandler {
-	return &MiddlewareHandler{}
+func NewMiddlewareHandler(client *caddy.Client) *MiddlewareHandler {
+	return &MiddlewareHandler{
+		configBuilder: caddy.NewConfigBuilder(client),
+	}
 }
 
 // GetMiddlewareSettings retrieves middleware settings for a site
@@ -36,10 +45,39 @@ func (h *MiddlewareHandler) GetMiddlewareSettings(c *gin.Context) {
 			CompressionEnabled:   false,
 			CompressionTypes:     "gzip",
 			CompressionLevel:     5,
+			CompressionMinLength: 512,
 			BasicAuthEnabled:     false,
 			BasicAuthRealm:       "Restricted",
 			AccessControlEnabled: false,
 			AccessControlDefault: "allow",
+			SecurityPreset:       "none",
+			ForwardAuthProvider:  "custom",
+		}
+	}
+
+	if settings.BasicAuthExcludeJSON != "" {
+		json.Unmarshal([]byte(settings.BasicAuthExcludeJSON), &settings.BasicAuthExclude)
+	}
+	if settings.CSPDirectivesJSON != "" {
+		json.Unmarshal([]byte(settings.CSPDirectivesJSON), &settings.CSPDirectives)
+	}
+	if settings.ForwardAuthCopyHeadersJSON != "" {
+		json.Unmarshal([]byte(settings.ForwardAuthCopyHeadersJSON), &settings.ForwardAuthCopyHeaders)
+	}
+	if settings.ForwardAuthExcludeJSON != "" {
+		json.Unmarshal([]byte(settings.ForwardAuthExcludeJSON), &settings.ForwardAuthExclude)
+	}
+	for _, field := range []struct {
+		column string
+		list   *[]string
+	}{
+		{settings.CORSAllowedOriginsJSON, &settings.CORSAllowedOrigins},
+		{settings.CORSAllowedMethodsJSON, &settings.CORSAllowedMethods},
+		{settings.CORSAllowedHeadersJSON, &settings.CORSAllowedHeaders},
+		{settings.CORSExposedHeadersJSON, &settings.CORSExposedHeaders},
+	} {
+		if field.column != "" {
+			json.Unmarshal([]byte(field.column), field.list)
 		}
 	}
 
@@ -63,6 +101,86 @@ func (h *MiddlewareHandler) UpdateMiddlewareSettings(c *gin.Context) {
 		return
 	}
 
+	if err := caddy.ValidateCompression(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	for _, path := range req.BasicAuthExclude {
+		if !strings.HasPrefix(path, "/") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "basic_auth_exclude paths must start with /: " + path})
+			return
+		}
+	}
+	excludeJSON, _ := json.Marshal(req.BasicAuthExclude)
+	req.BasicAuthExcludeJSON = string(excludeJSON)
+
+	if err := caddy.ValidateSecurityHeaders(req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if req.SecurityPreset == "" {
+		req.SecurityPreset = "none"
+	}
+	req.CSPDirectivesJSON = ""
+	if len(req.CSPDirectives) > 0 {
+		cspJSON, _ := json.Marshal(req.CSPDirectives)
+		req.CSPDirectivesJSON = string(cspJSON)
+	}
+
+	if req.ForwardAuthProvider == "" {
+		req.ForwardAuthProvider = "custom"
+	}
+	if req.ForwardAuthEnabled {
+		if err := caddy.ValidateForwardAuth(forwardAuthConfig(req)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	for _, path := range req.ForwardAuthExclude {
+		if !strings.HasPrefix(path, "/") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "forward_auth_exclude paths must start with /: " + path})
+			return
+		}
+	}
+	req.ForwardAuthCopyHeadersJSON = ""
+	if len(req.ForwardAuthCopyHeaders) > 0 {
+		copyJSON, _ := json.Marshal(req.ForwardAuthCopyHeaders)
+		req.ForwardAuthCopyHeadersJSON = string(copyJSON)
+	}
+	forwardExcludeJSON, _ := json.Marshal(req.ForwardAuthExclude)
+	req.ForwardAuthExcludeJSON = string(forwardExcludeJSON)
+
+	if req.CORSEnabled {
+		if err := caddy.ValidateCORSPolicy(corsPolicy(req)); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	for _, field := range []struct {
+		list   []string
+		column *string
+	}{
+		{req.CORSAllowedOrigins, &req.CORSAllowedOriginsJSON},
+		{req.CORSAllowedMethods, &req.CORSAllowedMethodsJSON},
+		{req.CORSAllowedHeaders, &req.CORSAllowedHeadersJSON},
+		{req.CORSExposedHeaders, &req.CORSExposedHeadersJSON},
+	} {
+		*field.column = ""
+		if len(field.list) > 0 {
+			listJSON, _ := json.Marshal(field.list)
+			*field.column = string(listJSON)
+		}
+	}
+
+	if req.AccessControlDefault == "" {
+		req.AccessControlDefault = "allow"
+	}
+	if req.AccessControlDefault != "allow" && req.AccessControlDefault != "deny" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "access_control_default must be allow or deny"})
+		return
+	}
+
 	var settings models.MiddlewareSettings
 	result := database.DB.Where("site_id = ?", siteID).First(&settings)
 	
@@ -73,6 +191,7 @@ func (h *MiddlewareHandler) UpdateMiddlewareSettings(c *gin.Context) {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 			return
 		}
+		h.syncToCaddy()
 		c.JSON(http.StatusOK, req)
 		return
 	}
@@ -81,19 +200,145 @@ func (h *MiddlewareHandler) UpdateMiddlewareSettings(c *gin.Context) {
 	settings.CompressionEnabled = req.CompressionEnabled
 	settings.CompressionTypes = req.CompressionTypes
 	settings.CompressionLevel = req.CompressionLevel
+	settings.CompressionMinLength = req.CompressionMinLength
 	settings.BasicAuthEnabled = req.BasicAuthEnabled
 	settings.BasicAuthRealm = req.BasicAuthRealm
+	settings.BasicAuthExcludeJSON = req.BasicAuthExcludeJSON
 	settings.AccessControlEnabled = req.AccessControlEnabled
 	settings.AccessControlDefault = req.AccessControlDefault
+	settings.SecurityPreset = req.SecurityPreset
+	settings.XContentTypeOptions = req.XContentTypeOptions
+	settings.XFrameOptions = req.XFrameOptions
+	settings.ReferrerPolicy = req.ReferrerPolicy
+	settings.PermissionsPolicy = req.PermissionsPolicy
+	settings.CSPDirectivesJSON = req.CSPDirectivesJSON
+	settings.CSPReportOnly = req.CSPReportOnly
+	settings.ForwardAuthEnabled = req.ForwardAuthEnabled
+	settings.ForwardAuthProvider = req.ForwardAuthProvider
+	settings.ForwardAuthUpstream = req.ForwardAuthUpstream
+	settings.ForwardAuthURI = req.ForwardAuthURI
+	settings.ForwardAuthCopyHeadersJSON = req.ForwardAuthCopyHeadersJSON
+	settings.ForwardAuthExcludeJSON = req.ForwardAuthExcludeJSON
+	settings.CORSEnabled = req.CORSEnabled
+	settings.CORSAllowedOriginsJSON = req.CORSAllowedOriginsJSON
+	settings.CORSAllowedMethodsJSON = req.CORSAllowedMethodsJSON
+	settings.CORSAllowedHeadersJSON = req.CORSAllowedHeadersJSON
+	settings.CORSExposedHeadersJSON = req.CORSExposedHeadersJSON
+	settings.CORSAllowCredentials = req.CORSAllowCredentials
+	settings.CORSMaxAge = req.CORSMaxAge
 
 	if err := database.DB.Save(&settings).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	h.syncToCaddy()
+
+	settings.BasicAuthExclude = req.BasicAuthExclude
+	settings.CSPDirectives = req.CSPDirectives
+	settings.ForwardAuthCopyHeaders = req.ForwardAuthCopyHeaders
+	settings.ForwardAuthExclude = req.ForwardAuthExclude
+	settings.CORSAllowedOrigins = req.CORSAllowedOrigins
+	settings.CORSAllowedMethods = req.CORSAllowedMethods
+	settings.CORSAllowedHeaders = req.CORSAllowedHeaders
+	settings.CORSExposedHeaders = req.CORSExposedHeaders
 	c.JSON(http.StatusOK, settings)
 }
 
+// forwardAuthConfig returns the forward auth configuration of a site's middleware settings
+func forwardAuthConfig(settings models.MiddlewareSettings) caddy.ForwardAuthConfig {
+	return caddy.ForwardAuthConfig{
+		Provider:    settings.ForwardAuthProvider,
+		Upstream:    settings.ForwardAuthUpstream,
+		URI:         settings.ForwardAuthURI,
+		CopyHeaders: settings.ForwardAuthCopyHeaders,
+	}
+}
+
+// corsPolicy returns the CORS policy of a site's middleware settings
+func corsPolicy(settings models.MiddlewareSettings) caddy.CORSPolicy {
+	return caddy.CORSPolicy{
+		AllowedOrigins:   settings.CORSAllowedOrigins,
+		AllowedMethods:   settings.CORSAllowedMethods,
+		AllowedHeaders:   settings.CORSAllowedHeaders,
+		ExposedHeaders:   settings.CORSExposedHeaders,
+		AllowCredentials: settings.CORSAllowCredentials,
+		MaxAge:           settings.CORSMaxAge,
+	}
+}
+
+// CheckForwardAuthRequest is the request body for testing a site's forward auth service
+type CheckForwardAuthRequest struct {
+	Method             string            `json:"method"`                 // original request method, defaults to GET
+	URL                string            `json:"url" binding:"required"` // original request URL, e.g. https://app.example.com/private
+	Headers            map[string]string `json:"headers"`                // e.g. a session Cookie
+	InsecureSkipVerify bool              `json:"insecure_skip_verify"`
+}
+
+// CheckForwardAuth sends a verification request to a site's auth service the way Caddy would
+// @Summary      Test forward auth
+// @Description  Send one verification request to the site's auth service and report the outcome
+// @Tags         sites
+// @Param        id       path      string                   true  "Site ID"
+// @Param        request  body      CheckForwardAuthRequest  true  "Original request"
+// @Success      200      {object}  caddy.ForwardAuthCheck
+// @Router       /sites/{id}/forward-auth/check [post]
+func (h *MiddlewareHandler) CheckForwardAuth(c *gin.Context) {
+	siteID := c.Param("id")
+
+	var req CheckForwardAuthRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var settings models.MiddlewareSettings
+	if err := database.DB.Where("site_id = ?", siteID).First(&settings).Error; err != nil || settings.ForwardAuthUpstream == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Forward auth is not configured for this site"})
+		return
+	}
+	if settings.ForwardAuthCopyHeadersJSON != "" {
+		json.Unmarshal([]byte(settings.ForwardAuthCopyHeadersJSON), &settings.ForwardAuthCopyHeaders)
+	}
+
+	check, err := caddy.CheckForwardAuth(forwardAuthConfig(settings), req.Method, req.URL, req.Headers, req.InsecureSkipVerify)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, check)
+}
+
+// GetHSTSPreloadCheck reports whether a site is ready for HSTS preload submission
+// @Summary      Check HSTS preload readiness
+// @Description  List the reasons a site does not yet meet HSTS preload requirements
+// @Tags         sites
+// @Param        id   path      string  true  "Site ID"
+// @Success      200  {object}  map[string]interface{}
+// @Router       /sites/{id}/security/hsts-preload [get]
+func (h *MiddlewareHandler) GetHSTSPreloadCheck(c *gin.Context) {
+	siteID := c.Param("id")
+
+	var site models.Site
+	if err := database.DB.First(&site, "id = ?", siteID).Error; err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found"})
+		return
+	}
+	json.Unmarshal([]byte(site.HostsJSON), &site.Hosts)
+
+	var settings models.GlobalSettings
+	database.DB.First(&settings)
+
+	warnings := caddy.CheckHSTSPreload(&site, &settings)
+	if warnings == nil {
+		warnings = []string{}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"ready":    len(warnings) == 0,
+		"warnings": warnings,
+	})
+}
+
 // --- Basic Auth Users ---
 
 // ListBasicAuthUsers lists all basic auth users for a site
@@ -168,6 +413,8 @@ func (h *MiddlewareHandler) CreateBasicAuthUser(c *gin.Context) {
 		return
 	}
 
+	h.syncToCaddy()
+
 	user.PasswordHash = "[hidden]"
 	c.JSON(http.StatusCreated, user)
 }
@@ -187,6 +434,8 @@ func (h *MiddlewareHandler) DeleteBasicAuthUser(c *gin.Context) {
 		return
 	}
 
+	h.syncToCaddy()
+
 	c.JSON(http.StatusOK, gin.H{"message": "User deleted"})
 }
 
@@ -217,6 +466,7 @@ type CreateHeaderRuleRequest struct {
 	Operation   string `json:"operation" binding:"required"` // set, add, delete
 	HeaderName  string `json:"header_name" binding:"required"`
 	HeaderValue string `json:"header_value"`
+	Replace     string `json:"replace"` // Replacement for the header_value search string (operation "replace")
 	Priority    int    `json:"priority"`
 }
 
@@ -243,15 +493,23 @@ func (h *MiddlewareHandler) CreateHeaderRule(c *gin.Context) {
 		Operation:   req.Operation,
 		HeaderName:  req.HeaderName,
 		HeaderValue: req.HeaderValue,
+		Replace:     req.Replace,
 		Priority:    req.Priority,
 		Enabled:     true,
 	}
 
+	if err := caddy.ValidateHeaderRule(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	if err := database.DB.Create(&rule).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	h.syncToCaddy()
+
 	c.JSON(http.StatusCreated, rule)
 }
 
@@ -259,17 +517,19 @@ func (h *MiddlewareHandler) CreateHeaderRule(c *gin.Context) {
 // @Summary      Delete header rule
 // @Description  Delete a header rule by ID
 // @Tags         header-rules
-// @Param        userId  path      string  true  "Rule ID"
-// @Success      200     {object}  map[string]string
-// @Router       /headers/{userId} [delete]
+// @Param        id   path      string  true  "Rule ID"
+// @Success      200  {object}  map[string]string
+// @Router       /headers/{id} [delete]
 func (h *MiddlewareHandler) DeleteHeaderRule(c *gin.Context) {
-	id := c.Param("userId")
+	id := c.Param("id")
 
 	if err := database.DB.Delete(&models.HeaderRule{}, "id = ?", id).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	h.syncToCaddy()
+
 	c.JSON(http.StatusOK, gin.H{"message": "Header rule deleted"})
 }
 
@@ -321,16 +581,23 @@ func (h *MiddlewareHandler) CreateAccessRule(c *gin.Context) {
 	rule := models.AccessRule{
 		SiteID:   siteID,
 		RuleType: req.RuleType,
-		CIDR:     req.CIDR,
+		CIDR:     strings.TrimSpace(req.CIDR),
 		Priority: req.Priority,
 		Enabled:  true,
 	}
 
+	if err := caddy.ValidateAccessRule(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	if err := database.DB.Create(&rule).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	h.syncToCaddy()
+
 	c.JSON(http.StatusCreated, rule)
 }
 
@@ -338,17 +605,19 @@ func (h *MiddlewareHandler) CreateAccessRule(c *gin.Context) {
 // @Summary      Delete access rule
 // @Description  Delete an access rule by ID
 // @Tags         access-rules
-// @Param        userId  path      string  true  "Rule ID"
-// @Success      200     {object}  map[string]string
-// @Router       /access-rules/{userId} [delete]
+// @Param        id   path      string  true  "Rule ID"
+// @Success      200  {object}  map[string]string
+// @Router       /access/{id} [delete]
 func (h *MiddlewareHandler) DeleteAccessRule(c *gin.Context) {
-	id := c.Param("userId")
+	id := c.Param("id")
 
 	if err := database.DB.Delete(&models.AccessRule{}, "id = ?", id).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	h.syncToCaddy()
+
 	c.JSON(http.StatusOK, gin.H{"message": "Access rule deleted"})
 }
 
@@ -413,11 +682,18 @@ func (h *MiddlewareHandler) CreateRewriteRule(c *gin.Context) {
 		rule.MatchType = "prefix"
 	}
 
+	if err := caddy.ValidateRewriteRule(rule); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	if err := database.DB.Create(&rule).Error; err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 	}
 
+	h.syncToCaddy()
+
 	c.JSON(http.StatusCreated, rule)
 }
 
@@ -435,6 +711,8 @@ func (h *MiddlewareHandler) DeleteRewriteRule(c *gin.Context) {
 		return
 	}
 
+	h.syncToCaddy()
+
 	c.JSON(http.StatusOK, gin.H{"message": "Rewrite rule deleted"})
 }
 
@@ -496,3 +774,125 @@ func (h *MiddlewareHandler) DeleteRedirectRule(c *gin.Context) {
 	}
 	c.JSON(http.StatusOK, gin.H{"message": "Redirect rule deleted"})
 }
+
+// --- Error Pages ---
+
+// ListErrorPages lists all error pages for a site
+// @Summary      List error pages
+// @Description  List the custom error pages of a specific site
+// @Tags         sites
+// @Param        id   path      string  true  "Site ID"
+// @Success      200  {object}  map[string][]models.ErrorPage
+// @Router       /sites/{id}/error-pages [get]
+func (h *MiddlewareHandler) ListErrorPages(c *gin.Context) {
+	siteID := c.Param("id")
+
+	var pages []models.ErrorPage
+	if err := database.DB.Where("site_id = ?", siteID).Order("priority DESC, created_at ASC").Find(&pages).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"pages": pages})
+}
+
+// CreateErrorPageRequest is the request body for creating an error page
+type CreateErrorPageRequest struct {
+	StatusCodes  string `json:"status_codes" binding:"required"` // e.g. "404", "502,503,504", "5xx", "400-499", "*"
+	Action       string `json:"action"`                          // file, static, redirect
+	FilePath     string `json:"file_path"`
+	Body         string `json:"body"`
+	ContentType  string `json:"content_type"`
+	Location     string `json:"location"`
+	RedirectCode int    `json:"redirect_code"`
+	Priority     int    `json:"priority"`
+}
+
+// CreateErrorPage creates a new error page
+// @Summary      Create error page
+// @Description  Serve an uploaded file, a static response or a redirect for matching error statuses
+// @Tags         sites
+// @Param        id    path      string                  true  "Site ID"
+// @Param        page  body      CreateErrorPageRequest  true  "Error page JSON"
+// @Success      201   {object}  models.ErrorPage
+// @Router       /sites/{id}/error-pages [post]
+func (h *MiddlewareHandler) CreateErrorPage(c *gin.Context) {
+	siteID := c.Param("id")
+
+	var req CreateErrorPageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	page := models.ErrorPage{
+		SiteID:       siteID,
+		StatusCodes:  req.StatusCodes,
+		Action:       req.Action,
+		FilePath:     req.FilePath,
+		Body:         req.Body,
+		ContentType:  req.ContentType,
+		Location:     req.Location,
+		RedirectCode: req.RedirectCode,
+		Priority:     req.Priority,
+		Enabled:      true,
+	}
+
+	if page.Action == "" {
+		page.Action = "file"
+	}
+	if page.Action == "redirect" && page.RedirectCode == 0 {
+		page.RedirectCode = 302
+	}
+
+	if err := caddy.ValidateErrorPage(page); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Files must already be uploaded to the site's public directory
+	if root := caddy.SiteRoot(siteID); page.Action == "file" && root != "" {
+		if info, err := os.Stat(filepath.Join(root, filepath.FromSlash(page.FilePath))); err != nil || info.IsDir() {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "File " + page.FilePath + " not found in the site's files"})
+			return
+		}
+	}
+
+	if err := database.DB.Create(&page).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.syncToCaddy()
+
+	c.JSON(http.StatusCreated, page)
+}
+
+// DeleteErrorPage deletes an error page
+// @Summary      Delete error page
+// @Description  Delete an error page by ID
+// @Tags         error-pages
+// @Param        id   path      string  true  "Error page ID"
+// @Success      200  {object}  map[string]string
+// @Router       /error-pages/{id} [delete]
+func (h *MiddlewareHandler) DeleteErrorPage(c *gin.Context) {
+	id := c.Param("id")
+	if err := database.DB.Delete(&models.ErrorPage{}, "id = ?", id).Error; err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	h.syncToCaddy()
+
+	c.JSON(http.StatusOK, gin.H{"message": "Error page deleted"})
+}
+
+// syncToCaddy rebuilds and applies configuration to Caddy
+func (h *MiddlewareHandler) syncToCaddy() error {
+	config, err := h.configBuilder.BuildFromDB()
+	if err != nil {
+		return err
+	}
+
+	return h.configBuilder.ApplyConfig(config)
+}
@@ -26,25 +26,29 @@ func NewRouteHandler(client *caddy.Client) *RouteHandler {
 
 // CreateRouteRequest represents a request to create a route
 type CreateRouteRequest struct {
-	Name          string   `json:"name"`
-	PathMatcher   string   `json:"path_matcher" binding:"required"`
-	MatchType     string   `json:"match_type"`
-	Methods       []string `json:"methods"`
-	HandlerType   string   `json:"handler_type" binding:"required"`
-	HandlerConfig string   `json:"handler_config"`
-	Order         int      `json:"order"`
+	Name          string                `json:"name"`
+	PathMatcher   string                `json:"path_matcher" binding:"required"`
+	MatchType     string                `json:"match_type"`
+	Methods       []string              `json:"methods"`
+	Matchers      *models.RouteMatchers `json:"matchers"`
+	HandlerType   string                `json:"handler_type"`   // Legacy single handler; ignored when handlers is set
+	HandlerConfig string                `json:"handler_config"` // Legacy single handler config
+	Handlers      []models.HandlerStep  `json:"handlers"`
+	Order         int                   `json:"order"`
 }
 
 // UpdateRouteRequest represents a request to update a route
 type UpdateRouteRequest struct {
-	Name          string   `json:"name"`
-	PathMatcher   string   `json:"path_matcher"`
-	MatchType     string   `json:"match_type"`
-	Methods       []string `json:"methods"`
-	HandlerType   string   `json:"handler_type"`
-	HandlerConfig string   `json:"handler_config"`
-	Order         *int     `json:"order"`
-	Enabled       *bool    `json:"enabled"`
+	Name          string                `json:"name"`
+	PathMatcher   string                `json:"path_matcher"`
+	MatchType     string                `json:"match_type"`
+	Methods       []string              `json:"methods"`
+	Matchers      *models.RouteMatchers `json:"matchers"`
+	HandlerType   string                `json:"handler_type"`
+	HandlerConfig string                `json:"handler_config"`
+	Handlers      []models.HandlerStep  `json:"handlers"`
+	Order         *int                  `json:"order"`
+	Enabled       *bool                 `json:"enabled"`
 }
 
 // ListRoutes returns all routes for a site
@@ -66,11 +70,9 @@ func (h *RouteHandler) ListRoutes(c *gin.Context) {
 		return
 	}
 
-	// Parse methods JSON
+	// Parse JSON fields
 	for i := range routes {
-		if routes[i].MethodsJSON != "" {
-			json.Unmarshal([]byte(routes[i].MethodsJSON), &routes[i].Methods)
-		}
+		decodeRouteFields(&routes[i])
 	}
 
 	c.JSON(http.StatusOK, gin.H{"routes": routes})
@@ -88,9 +90,7 @@ func (h *RouteHandler) GetRoute(c *gin.Context) {
 		return
 	}
 
-	if route.MethodsJSON != "" {
-		json.Unmarshal([]byte(route.MethodsJSON), &route.Methods)
-	}
+	decodeRouteFields(&route)
 
 	c.JSON(http.StatusOK, route)
 }
@@ -113,24 +113,58 @@ func (h *RouteHandler) CreateRoute(c *gin.Context) {
 		return
 	}
 
+	if err := caddy.ValidateRouteMatchers(req.Matchers); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	methodsJSON, _ := json.Marshal(req.Methods)
-	
+	var matchersJSON []byte
+	if req.Matchers != nil {
+		matchersJSON, _ = json.Marshal(req.Matchers)
+	}
+
 	route := models.Route{
 		SiteID:        siteID,
 		Name:          req.Name,
 		PathMatcher:   req.PathMatcher,
 		MatchType:     req.MatchType,
 		MethodsJSON:   string(methodsJSON),
+		MatchersJSON:  string(matchersJSON),
 		HandlerType:   req.HandlerType,
 		HandlerConfig: req.HandlerConfig,
 		Order:         req.Order,
 		Enabled:       true,
 	}
 
+	steps := req.Handlers
+	if len(steps) == 0 {
+		if req.HandlerType == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "handler_type or handlers is required"})
+			return
+		}
+		legacySteps, err := route.HandlerSteps()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid handler_config: " + err.Error()})
+			return
+		}
+		steps = legacySteps
+	}
+	if err := caddy.ValidateHandlerSteps(steps); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	route.SetHandlerSteps(steps)
+
 	if route.MatchType == "" {
 		route.MatchType = "path"
 	}
 
+	if err := caddy.ValidateRouteMatch(route.MatchType, route.PathMatcher); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	result := database.GetDB().Create(&route)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
@@ -149,10 +183,24 @@ func (h *RouteHandler) CreateRoute(c *gin.Context) {
 	}
 	database.GetDB().Create(&history)
 
+	route.Methods = req.Methods
+	route.Matchers = req.Matchers
+
 	// Sync to Caddy
-	h.syncToCaddy()
+	warnings, err := h.syncToCaddy()
+	if err != nil {
+		c.JSON(http.StatusCreated, gin.H{
+			"route":   route,
+			"warning": "Route created but failed to sync to Caddy: " + err.Error(),
+		})
+		return
+	}
+	if len(warnings) > 0 {
+		// Report what the build skipped, this route included if its handlers could not be built
+		c.JSON(http.StatusCreated, gin.H{"route": route, "warnings": warnings})
+		return
+	}
 
-	route.Methods = req.Methods
 	c.JSON(http.StatusCreated, route)
 }
 
@@ -167,6 +215,7 @@ func (h *RouteHandler) UpdateRoute(c *gin.Context) {
 		return
 	}
 
+	decodeRouteFields(&route)
 	previousState, _ := json.Marshal(route)
 
 	var req UpdateRouteRequest
@@ -188,11 +237,44 @@ func (h *RouteHandler) UpdateRoute(c *gin.Context) {
 		methodsJSON, _ := json.Marshal(req.Methods)
 		route.MethodsJSON = string(methodsJSON)
 	}
-	if req.HandlerType != "" {
-		route.HandlerType = req.HandlerType
+	if req.Matchers != nil {
+		if err := caddy.ValidateRouteMatchers(req.Matchers); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		matchersJSON, _ := json.Marshal(req.Matchers)
+		route.MatchersJSON = string(matchersJSON)
 	}
-	if req.HandlerConfig != "" {
-		route.HandlerConfig = req.HandlerConfig
+	if req.Handlers != nil {
+		if err := caddy.ValidateHandlerSteps(req.Handlers); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		route.SetHandlerSteps(req.Handlers)
+	} else if req.HandlerType != "" || req.HandlerConfig != "" {
+		// Legacy update: replace the terminal step of the pipeline
+		steps, err := route.HandlerSteps()
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		last := steps[len(steps)-1]
+		if req.HandlerType != "" {
+			last.Type = req.HandlerType
+		}
+		if req.HandlerConfig != "" {
+			last.Config = nil
+			if err := json.Unmarshal([]byte(req.HandlerConfig), &last.Config); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid handler_config: " + err.Error()})
+				return
+			}
+		}
+		steps[len(steps)-1] = last
+		if err := caddy.ValidateHandlerSteps(steps); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		route.SetHandlerSteps(steps)
 	}
 	if req.Order != nil {
 		route.Order = *req.Order
@@ -201,6 +283,11 @@ func (h *RouteHandler) UpdateRoute(c *gin.Context) {
 		route.Enabled = *req.Enabled
 	}
 
+	if err := caddy.ValidateRouteMatch(route.MatchType, route.PathMatcher); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	result := database.GetDB().Save(&route)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
@@ -208,6 +295,7 @@ func (h *RouteHandler) UpdateRoute(c *gin.Context) {
 	}
 
 	// Record history
+	decodeRouteFields(&route)
 	newState, _ := json.Marshal(route)
 	history := models.ConfigHistory{
 		Action:        "update",
@@ -221,9 +309,20 @@ func (h *RouteHandler) UpdateRoute(c *gin.Context) {
 	database.GetDB().Create(&history)
 
 	// Sync to Caddy
-	h.syncToCaddy()
+	warnings, err := h.syncToCaddy()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"route":   route,
+			"warning": "Route updated but failed to sync to Caddy: " + err.Error(),
+		})
+		return
+	}
+	if len(warnings) > 0 {
+		// Report what the build skipped, this route included if its handlers could not be built
+		c.JSON(http.StatusOK, gin.H{"route": route, "warnings": warnings})
+		return
+	}
 
-	json.Unmarshal([]byte(route.MethodsJSON), &route.Methods)
 	c.JSON(http.StatusOK, route)
 }
 
@@ -238,6 +337,7 @@ func (h *RouteHandler) DeleteRoute(c *gin.Context) {
 		return
 	}
 
+	decodeRouteFields(&route)
 	previousState, _ := json.Marshal(route)
 
 	result := database.GetDB().Delete(&route)
@@ -263,13 +363,24 @@ func (h *RouteHandler) DeleteRoute(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"message": "Route deleted successfully"})
 }
 
-// syncToCaddy is similar to the one in sites.go
-func (h *RouteHandler) syncToCaddy() error {
+// decodeRouteFields fills the JSON-backed fields of a route for API responses
+func decodeRouteFields(route *models.Route) {
+	if route.MethodsJSON != "" {
+		json.Unmarshal([]byte(route.MethodsJSON), &route.Methods)
+	}
+	if route.MatchersJSON != "" {
+		json.Unmarshal([]byte(route.MatchersJSON), &route.Matchers)
+	}
+	route.Handlers, _ = route.HandlerSteps()
+}
+
+// syncToCaddy is similar to the one in sites.go, and returns what the build skipped
+func (h *RouteHandler) syncToCaddy() ([]string, error) {
 	// Build configuration from database
 	config, err := h.configBuilder.BuildFromDB()
 	if err != nil {
-		return err
+		return nil, err
 	}
 
-	return h.configBuilder.ApplyConfig(config)
+	return config.Warnings, h.configBuilder.ApplyConfig(config)
 }
@@ -0,0 +1,101 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"caddyadmin/caddy"
+	"caddyadmin/database"
+	"caddyadmin/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetServerSettings gets the server overrides of a site
+// GET /api/sites/:id/server
+func (h *SiteHandler) GetServerSettings(c *gin.Context) {
+	siteID := c.Param("id")
+
+	var settings models.ServerSettings
+	if err := database.GetDB().Where("site_id = ?", siteID).First(&settings).Error; err != nil {
+		// Nothing overridden, everything comes from the global settings
+		settings = models.ServerSettings{SiteID: siteID}
+	}
+	c.JSON(http.StatusOK, settings)
+}
+
+// UpdateServerSettings updates the server overrides of a site. Sites sharing a listen
+// port share one Caddy server, so the overrides apply to all of them.
+// PUT /api/sites/:id/server
+func (h *SiteHandler) UpdateServerSettings(c *gin.Context) {
+	siteID := c.Param("id")
+
+	var site models.Site
+	if result := database.GetDB().First(&site, "id = ?", siteID); result.Error != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Site not found"})
+		return
+	}
+
+	var settings models.ServerSettings
+	database.GetDB().Where("site_id = ?", siteID).First(&settings)
+
+	previousState, _ := json.Marshal(settings)
+
+	var req models.ServerSettings
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := caddy.ValidateServerTuning(caddy.SiteServerTuning(req)); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	settings.SiteID = siteID
+	settings.ReadTimeout = req.ReadTimeout
+	settings.ReadHeaderTimeout = req.ReadHeaderTimeout
+	settings.WriteTimeout = req.WriteTimeout
+	settings.IdleTimeout = req.IdleTimeout
+	settings.MaxHeaderBytes = req.MaxHeaderBytes
+	settings.TrustedProxies = req.TrustedProxies
+	settings.ClientIPHeaders = req.ClientIPHeaders
+	settings.Protocols = req.Protocols
+	settings.ProxyProtocol = req.ProxyProtocol
+	settings.ProxyProtocolAllow = req.ProxyProtocolAllow
+	settings.StrictSNIHost = req.StrictSNIHost
+
+	var result error
+	if settings.ID == "" {
+		result = database.GetDB().Create(&settings).Error
+	} else {
+		result = database.GetDB().Save(&settings).Error
+	}
+	if result != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error()})
+		return
+	}
+
+	// Record history
+	newState, _ := json.Marshal(settings)
+	history := models.ConfigHistory{
+		Action:        "update",
+		ResourceType:  "server_settings",
+		ResourceID:    settings.ID,
+		ResourceName:  site.Name + "_server",
+		PreviousState: string(previousState),
+		NewState:      string(newState),
+		Success:       true,
+	}
+	database.GetDB().Create(&history)
+
+	// Sync to Caddy
+	if err := h.syncToCaddy(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"settings": settings,
+			"warning":  "Settings saved but failed to sync to Caddy: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, settings)
+}
@@ -136,6 +136,16 @@ func (h *SiteHandler) CreateSite(c *gin.Context) {
 	if site.ListenPort == 0 {
 		site.ListenPort = 443
 	}
+	site.Hosts = req.Hosts
+
+	// Reject hosts already served by another site on the same port
+	if conflicts := siteHostConflicts(site); len(conflicts) > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":     "Hosts are already claimed by another site on this port",
+			"conflicts": conflicts,
+		})
+		return
+	}
 
 	// Save to database
 	result := database.GetDB().Create(&site)
@@ -210,6 +220,16 @@ func (h *SiteHandler) UpdateSite(c *gin.Context) {
 		site.Enabled = *req.Enabled
 	}
 
+	// Reject hosts already served by another site on the same port
+	json.Unmarshal([]byte(site.HostsJSON), &site.Hosts)
+	if conflicts := siteHostConflicts(site); len(conflicts) > 0 {
+		c.JSON(http.StatusConflict, gin.H{
+			"error":     "Hosts are already claimed by another site on this port",
+			"conflicts": conflicts,
+		})
+		return
+	}
+
 	result := database.GetDB().Save(&site)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
@@ -232,10 +252,25 @@ func (h *SiteHandler) UpdateSite(c *gin.Context) {
 	// Sync to Caddy
 	h.syncToCaddy()
 
-	json.Unmarshal([]byte(site.HostsJSON), &site.Hosts)
 	c.JSON(http.StatusOK, site)
 }
 
+// ListHostConflicts returns hosts claimed by more than one enabled site on the same port
+// GET /api/sites/conflicts
+func (h *SiteHandler) ListHostConflicts(c *gin.Context) {
+	sites, err := loadSitesWithHosts()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	conflicts := caddy.FindHostConflicts(sites)
+	if conflicts == nil {
+		conflicts = []caddy.HostConflict{}
+	}
+	c.JSON(http.StatusOK, gin.H{"conflicts": conflicts})
+}
+
 // DeleteSite deletes a site
 // DELETE /api/sites/:id
 // DeleteSite deletes a site
@@ -303,3 +338,53 @@ func (h *SiteHandler) syncToCaddy() error {
 	// Apply to Caddy
 	return h.configBuilder.ApplyConfig(config)
 }
+
+// loadSitesWithHosts loads all sites with their hosts decoded
+func loadSitesWithHosts() ([]models.Site, error) {
+	var sites []models.Site
+	if err := database.GetDB().Find(&sites).Error; err != nil {
+		return nil, err
+	}
+	for i := range sites {
+		if sites[i].HostsJSON != "" {
+			json.Unmarshal([]byte(sites[i].HostsJSON), &sites[i].Hosts)
+		}
+	}
+	return sites, nil
+}
+
+// siteHostConflicts returns the host conflicts the given site would cause
+// alongside the stored sites. Disabled sites never conflict.
+func siteHostConflicts(site models.Site) []caddy.HostConflict {
+	if !site.Enabled {
+		return nil
+	}
+
+	sites, err := loadSitesWithHosts()
+	if err != nil {
+		return nil
+	}
+
+	// Replace the stored copy of the site, or add it when it is new
+	replaced := false
+	for i := range sites {
+		if site.ID != "" && sites[i].ID == site.ID {
+			sites[i] = site
+			replaced = true
+		}
+	}
+	if !replaced {
+		sites = append(sites, site)
+	}
+
+	var conflicts []caddy.HostConflict
+	for _, conflict := range caddy.FindHostConflicts(sites) {
+		for _, id := range conflict.SiteIDs {
+			if id == site.ID {
+				conflicts = append(conflicts, conflict)
+				break
+			}
+		}
+	}
+	return conflicts
+}
@@ -5,21 +5,44 @@ import (
 	"caddyadmin/database"
 	"caddyadmin/models"
 	"encoding/json"
+	"log"
+	"net"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 // TLSHandler handles TLS/certificate-related endpoints
 type TLSHandler struct {
-	caddyClient *caddy.Client
+	caddyClient   *caddy.Client
+	configBuilder *caddy.ConfigBuilder
+	askAllowed    []*net.IPNet // networks besides loopback that may call the ask endpoint
 }
 
 // NewTLSHandler creates a new TLS handler
-func NewTLSHandler(client *caddy.Client) *TLSHandler {
-	return &TLSHandler{
-		caddyClient: client,
+func NewTLSHandler(client *caddy.Client, askAllowed []string) *TLSHandler {
+	h := &TLSHandler{
+		caddyClient:   client,
+		configBuilder: caddy.NewConfigBuilder(client),
 	}
+
+	for _, entry := range askAllowed {
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, network, err := net.ParseCIDR(entry)
+		if err != nil {
+			log.Printf("Warning: ignoring invalid on-demand ask network %q", entry)
+			continue
+		}
+		h.askAllowed = append(h.askAllowed, network)
+	}
+	return h
 }
 
 // CreateTLSConfigRequest represents a request to create TLS config
@@ -30,10 +53,27 @@ type CreateTLSConfigRequest struct {
 	ACMEProvider    string `json:"acme_provider"`
 	OnDemandTLS     bool   `json:"on_demand_tls"`
 	WildcardCert    bool   `json:"wildcard_cert"`
+	CertificateID   string `json:"certificate_id"`
 	CustomCertPath  string `json:"custom_cert_path"`
 	CustomKeyPath   string `json:"custom_key_path"`
 	MinVersion      string `json:"min_version"`
 	CipherSuites    string `json:"cipher_suites"`
+	Curves          string `json:"curves"`
+	ALPN            string `json:"alpn"`
+
+	ACMEDirectory       string `json:"acme_directory"`
+	ACMEStaging         bool   `json:"acme_staging"`
+	ACMEEABKeyID        string `json:"acme_eab_key_id"`
+	ACMEEABMACKey       string `json:"acme_eab_mac_key"`
+	ACMEPreferredChains string `json:"acme_preferred_chains"`
+	ACMETrustedRoots    string `json:"acme_trusted_roots"`
+	KeyType             string `json:"key_type"`
+}
+
+// CheckACMEDirectoryRequest represents a request to probe an ACME directory
+type CheckACMEDirectoryRequest struct {
+	DirectoryURL       string `json:"directory_url" binding:"required"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify"` // for CAs with a private root, e.g. Pebble
 }
 
 // GetTLSConfig gets TLS config for a site
@@ -84,10 +124,20 @@ func (h *TLSHandler) UpdateTLSConfig(c *gin.Context) {
 	config.ACMEProvider = req.ACMEProvider
 	config.OnDemandTLS = req.OnDemandTLS
 	config.WildcardCert = req.WildcardCert
+	config.CertificateID = req.CertificateID
 	config.CustomCertPath = req.CustomCertPath
 	config.CustomKeyPath = req.CustomKeyPath
 	config.MinVersion = req.MinVersion
 	config.CipherSuites = req.CipherSuites
+	config.Curves = req.Curves
+	config.ALPN = req.ALPN
+	config.ACMEDirectory = req.ACMEDirectory
+	config.ACMEStaging = req.ACMEStaging
+	config.ACMEEABKeyID = req.ACMEEABKeyID
+	config.ACMEEABMACKey = req.ACMEEABMACKey
+	config.ACMEPreferredChains = req.ACMEPreferredChains
+	config.ACMETrustedRoots = req.ACMETrustedRoots
+	config.KeyType = req.KeyType
 
 	if config.ACMEProvider == "" {
 		config.ACMEProvider = "letsencrypt"
@@ -96,6 +146,38 @@ func (h *TLSHandler) UpdateTLSConfig(c *gin.Context) {
 		config.MinVersion = "tls1.2"
 	}
 
+	if err := caddy.ValidateTLSProtocols(config.MinVersion, config.CipherSuites, config.Curves, config.ALPN); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := caddy.ValidateACMESettings(config.ACMEProvider, config.ACMEDirectory, config.ACMEEABKeyID, config.ACMEEABMACKey, config.KeyType); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	var globalSettings models.GlobalSettings
+	database.GetDB().First(&globalSettings)
+	if err := caddy.ValidateIssuer(&config, &globalSettings); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// A site serves either an uploaded certificate or a file pair, never both
+	if (config.CustomCertPath == "") != (config.CustomKeyPath == "") {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "custom_cert_path and custom_key_path must be set together"})
+		return
+	}
+	if config.CertificateID != "" {
+		if config.CustomCertPath != "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "certificate_id cannot be combined with custom_cert_path"})
+			return
+		}
+		var cert models.CustomCertificate
+		if err := database.GetDB().First(&cert, "id = ?", config.CertificateID).Error; err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Certificate not found"})
+			return
+		}
+	}
+
 	var result error
 	if config.ID == "" {
 		result = database.GetDB().Create(&config).Error
@@ -120,9 +202,76 @@ func (h *TLSHandler) UpdateTLSConfig(c *gin.Context) {
 	}
 	database.GetDB().Create(&history)
 
+	// Sync to Caddy
+	h.syncToCaddy()
+
 	c.JSON(http.StatusOK, config)
 }
 
+// CheckACMEDirectory verifies that an ACME directory is reachable and well-formed
+// POST /api/tls/acme/check
+func (h *TLSHandler) CheckACMEDirectory(c *gin.Context) {
+	var req CheckACMEDirectoryRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if err := caddy.ValidateACMESettings("custom", req.DirectoryURL, "", "", ""); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	directory, err := caddy.CheckACMEDirectory(req.DirectoryURL, req.InsecureSkipVerify)
+	if err != nil {
+		c.JSON(http.StatusBadGateway, gin.H{"error": err.Error()})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"directory": directory})
+}
+
+// AskOnDemand approves on-demand certificates for hosts of enabled sites.
+// Caddy calls it before issuing; only loopback and configured networks may ask.
+// GET /api/tls/ask?domain=example.com
+func (h *TLSHandler) AskOnDemand(c *gin.Context) {
+	// RemoteIP ignores X-Forwarded-For, which a remote caller could forge
+	ip := net.ParseIP(c.RemoteIP())
+	if ip == nil || !h.askPermitted(ip) {
+		c.Status(http.StatusForbidden)
+		return
+	}
+
+	domain := c.Query("domain")
+	if domain == "" {
+		c.Status(http.StatusBadRequest)
+		return
+	}
+
+	var sites []models.Site
+	database.GetDB().Where("enabled = ?", true).Find(&sites)
+	for _, site := range sites {
+		var hosts []string
+		json.Unmarshal([]byte(site.HostsJSON), &hosts)
+		if caddy.MatchSiteHost(hosts, domain) {
+			c.Status(http.StatusOK)
+			return
+		}
+	}
+	c.Status(http.StatusNotFound)
+}
+
+func (h *TLSHandler) askPermitted(ip net.IP) bool {
+	if ip.IsLoopback() {
+		return true
+	}
+	for _, network := range h.askAllowed {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
 // GetPKICA gets PKI CA information
 // GET /api/pki/ca/:id
 func (h *TLSHandler) GetPKICA(c *gin.Context) {
@@ -154,3 +303,13 @@ func (h *TLSHandler) GetPKICACertificates(c *gin.Context) {
 	}
 	c.Data(http.StatusOK, "application/json", resp.Body)
 }
+
+// syncToCaddy rebuilds and applies configuration to Caddy
+func (h *TLSHandler) syncToCaddy() error {
+	config, err := h.configBuilder.BuildFromDB()
+	if err != nil {
+		return err
+	}
+
+	return h.configBuilder.ApplyConfig(config)
+}
@@ -5,6 +5,7 @@ import (
 	"caddyadmin/database"
 	"caddyadmin/models"
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
@@ -34,6 +35,11 @@ type CreateUpstreamRequest struct {
 	MaxConnections    int    `json:"max_connections"`
 	HealthCheckPath   string `json:"health_check_path"`
 	HealthCheckInterval int  `json:"health_check_interval"`
+	TLSServerName         string `json:"tls_server_name"`
+	TLSInsecureSkipVerify bool   `json:"tls_insecure_skip_verify"`
+	TLSRootCAID           string `json:"tls_root_ca_id"`
+	TLSClientCertPath     string `json:"tls_client_cert_path"`
+	TLSClientKeyPath      string `json:"tls_client_key_path"`
 }
 
 // CreateUpstreamGroupRequest represents a request to create an upstream group
@@ -46,6 +52,20 @@ type CreateUpstreamGroupRequest struct {
 	PassiveHealth bool     `json:"passive_health"`
 	Retries       int      `json:"retries"`
 	UpstreamIDs   []string `json:"upstream_ids"`
+
+	LoadBalancingHeader       string `json:"load_balancing_header"`
+	LoadBalancingCookie       string `json:"load_balancing_cookie"`
+	LoadBalancingCookieSecret string `json:"load_balancing_cookie_secret"`
+	LoadBalancingQueryKey     string `json:"load_balancing_query_key"`
+
+	HealthCheckPath         string            `json:"health_check_path"`
+	HealthCheckTimeout      int               `json:"health_check_timeout"`
+	HealthCheckExpectStatus int               `json:"health_check_expect_status"`
+	HealthCheckHeaders      map[string]string `json:"health_check_headers"`
+	PassiveFailDuration     int               `json:"passive_fail_duration"`
+	PassiveMaxFails         int               `json:"passive_max_fails"`
+	PassiveUnhealthyStatus  []int             `json:"passive_unhealthy_status"`
+	PassiveUnhealthyLatency int               `json:"passive_unhealthy_latency"`
 }
 
 // ListUpstreams returns all upstreams
@@ -92,6 +112,11 @@ func (h *UpstreamHandler) CreateUpstream(c *gin.Context) {
 		MaxConnections:    req.MaxConnections,
 		HealthCheckPath:   req.HealthCheckPath,
 		HealthCheckInterval: req.HealthCheckInterval,
+		TLSServerName:         req.TLSServerName,
+		TLSInsecureSkipVerify: req.TLSInsecureSkipVerify,
+		TLSRootCAID:           req.TLSRootCAID,
+		TLSClientCertPath:     req.TLSClientCertPath,
+		TLSClientKeyPath:      req.TLSClientKeyPath,
 		Healthy:           true,
 		Enabled:           true,
 	}
@@ -103,6 +128,11 @@ func (h *UpstreamHandler) CreateUpstream(c *gin.Context) {
 		upstream.Weight = 1
 	}
 
+	if err := validateUpstream(upstream); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
 	result := database.GetDB().Create(&upstream)
 	if result.Error != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
@@ -141,6 +171,35 @@ func (h *UpstreamHandler) UpdateUpstream(c *gin.Context) {
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 		return
 	}
+	upstream.ID = id
+
+	if err := validateUpstream(upstream); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// The updated upstream must still share a transport with the rest of its groups
+	var groups []models.UpstreamGroup
+	database.GetDB().Preload("Upstreams").
+		Joins("JOIN upstream_group_members ON upstream_group_members.upstream_group_id = upstream_groups.id").
+		Where("upstream_group_members.upstream_id = ?", id).
+		Find(&groups)
+	for _, group := range groups {
+		members := []models.Upstream{upstream}
+		for _, member := range group.Upstreams {
+			if member.ID != id {
+				members = append(members, member)
+			}
+		}
+		if err := caddy.ValidateUpstreamTransport(members); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "upstream group " + group.Name + ": " + err.Error()})
+			return
+		}
+		if err := caddy.ValidateGroupHealthCheckPaths(group, members); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "upstream group " + group.Name + ": " + err.Error()})
+			return
+		}
+	}
 
 	result := database.GetDB().Save(&upstream)
 	if result.Error != nil {
@@ -212,6 +271,9 @@ func (h *UpstreamHandler) ListUpstreamGroups(c *gin.Context) {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": result.Error.Error()})
 		return
 	}
+	for i := range groups {
+		decodeGroupFields(&groups[i])
+	}
 	c.JSON(http.StatusOK, gin.H{"upstream_groups": groups})
 }
 
@@ -226,6 +288,7 @@ func (h *UpstreamHandler) GetUpstreamGroup(c *gin.Context) {
 		c.JSON(http.StatusNotFound, gin.H{"error": "Upstream group not found"})
 		return
 	}
+	decodeGroupFields(&group)
 	c.JSON(http.StatusOK, group)
 }
 
@@ -241,12 +304,17 @@ func (h *UpstreamHandler) CreateUpstreamGroup(c *gin.Context) {
 	group := models.UpstreamGroup{
 		Name:          req.Name,
 		LoadBalancing: req.LoadBalancing,
+		LoadBalancingHeader:       req.LoadBalancingHeader,
+		LoadBalancingCookie:       req.LoadBalancingCookie,
+		LoadBalancingCookieSecret: req.LoadBalancingCookieSecret,
+		LoadBalancingQueryKey:     req.LoadBalancingQueryKey,
 		TryDuration:   req.TryDuration,
 		TryInterval:   req.TryInterval,
 		HealthChecks:  req.HealthChecks,
 		PassiveHealth: req.PassiveHealth,
 		Retries:       req.Retries,
 	}
+	applyHealthCheckSettings(&group, req)
 
 	if group.LoadBalancing == "" {
 		group.LoadBalancing = "round_robin"
@@ -257,6 +325,23 @@ func (h *UpstreamHandler) CreateUpstreamGroup(c *gin.Context) {
 	if group.Retries == 0 {
 		group.Retries = 3
 	}
+	if err := caddy.ValidateUpstreamGroup(group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	var upstreams []models.Upstream
+	if len(req.UpstreamIDs) > 0 {
+		database.GetDB().Where("id IN ?", req.UpstreamIDs).Find(&upstreams)
+		if err := caddy.ValidateUpstreamTransport(upstreams); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+	if err := caddy.ValidateGroupHealthCheckPaths(group, upstreams); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	result := database.GetDB().Create(&group)
 	if result.Error != nil {
@@ -265,9 +350,7 @@ func (h *UpstreamHandler) CreateUpstreamGroup(c *gin.Context) {
 	}
 
 	// Add upstreams to group
-	if len(req.UpstreamIDs) > 0 {
-		var upstreams []models.Upstream
-		database.GetDB().Where("id IN ?", req.UpstreamIDs).Find(&upstreams)
+	if len(upstreams) > 0 {
 		database.GetDB().Model(&group).Association("Upstreams").Append(&upstreams)
 	}
 
@@ -297,6 +380,7 @@ func (h *UpstreamHandler) UpdateUpstreamGroup(c *gin.Context) {
 		return
 	}
 
+	decodeGroupFields(&group)
 	previousState, _ := json.Marshal(group)
 
 	var req CreateUpstreamGroupRequest
@@ -305,13 +389,42 @@ func (h *UpstreamHandler) UpdateUpstreamGroup(c *gin.Context) {
 		return
 	}
 
+	var upstreams []models.Upstream
+	if len(req.UpstreamIDs) > 0 {
+		database.GetDB().Where("id IN ?", req.UpstreamIDs).Find(&upstreams)
+		if err := caddy.ValidateUpstreamTransport(upstreams); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
 	group.Name = req.Name
 	group.LoadBalancing = req.LoadBalancing
+	group.LoadBalancingHeader = req.LoadBalancingHeader
+	group.LoadBalancingCookie = req.LoadBalancingCookie
+	group.LoadBalancingCookieSecret = req.LoadBalancingCookieSecret
+	group.LoadBalancingQueryKey = req.LoadBalancingQueryKey
 	group.TryDuration = req.TryDuration
 	group.TryInterval = req.TryInterval
 	group.HealthChecks = req.HealthChecks
 	group.PassiveHealth = req.PassiveHealth
 	group.Retries = req.Retries
+	applyHealthCheckSettings(&group, req)
+
+	if err := caddy.ValidateUpstreamGroup(group); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	// Without new upstream IDs the group keeps its members, which must fit the new settings
+	members := upstreams
+	if len(req.UpstreamIDs) == 0 {
+		database.GetDB().Model(&group).Association("Upstreams").Find(&members)
+	}
+	if err := caddy.ValidateGroupHealthCheckPaths(group, members); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
 
 	result := database.GetDB().Save(&group)
 	if result.Error != nil {
@@ -322,8 +435,6 @@ func (h *UpstreamHandler) UpdateUpstreamGroup(c *gin.Context) {
 	// Update upstreams association
 	if len(req.UpstreamIDs) > 0 {
 		database.GetDB().Model(&group).Association("Upstreams").Clear()
-		var upstreams []models.Upstream
-		database.GetDB().Where("id IN ?", req.UpstreamIDs).Find(&upstreams)
 		database.GetDB().Model(&group).Association("Upstreams").Append(&upstreams)
 	}
 
@@ -396,6 +507,63 @@ func (h *UpstreamHandler) GetUpstreamStatus(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"upstreams": status})
 }
 
+// applyHealthCheckSettings copies the health check fields of a request onto a group
+func applyHealthCheckSettings(group *models.UpstreamGroup, req CreateUpstreamGroupRequest) {
+	group.HealthCheckPath = req.HealthCheckPath
+	group.HealthCheckTimeout = req.HealthCheckTimeout
+	group.HealthCheckExpectStatus = req.HealthCheckExpectStatus
+	group.HealthCheckHeaders = req.HealthCheckHeaders
+	group.PassiveFailDuration = req.PassiveFailDuration
+	group.PassiveMaxFails = req.PassiveMaxFails
+	group.PassiveUnhealthyStatus = req.PassiveUnhealthyStatus
+	group.PassiveUnhealthyLatency = req.PassiveUnhealthyLatency
+
+	if group.HealthCheckTimeout == 0 {
+		group.HealthCheckTimeout = 5
+	}
+	if group.PassiveHealth && group.PassiveFailDuration == 0 {
+		group.PassiveFailDuration = 30
+	}
+	if group.PassiveHealth && group.PassiveMaxFails == 0 {
+		group.PassiveMaxFails = 1
+	}
+
+	group.HealthCheckHeadersJSON = ""
+	if len(group.HealthCheckHeaders) > 0 {
+		headersJSON, _ := json.Marshal(group.HealthCheckHeaders)
+		group.HealthCheckHeadersJSON = string(headersJSON)
+	}
+	group.PassiveUnhealthyStatusJSON = ""
+	if len(group.PassiveUnhealthyStatus) > 0 {
+		statusJSON, _ := json.Marshal(group.PassiveUnhealthyStatus)
+		group.PassiveUnhealthyStatusJSON = string(statusJSON)
+	}
+}
+
+// decodeGroupFields populates the JSON-backed fields of an upstream group
+func decodeGroupFields(group *models.UpstreamGroup) {
+	if group.HealthCheckHeadersJSON != "" {
+		json.Unmarshal([]byte(group.HealthCheckHeadersJSON), &group.HealthCheckHeaders)
+	}
+	if group.PassiveUnhealthyStatusJSON != "" {
+		json.Unmarshal([]byte(group.PassiveUnhealthyStatusJSON), &group.PassiveUnhealthyStatus)
+	}
+}
+
+// validateUpstream checks the upstream's scheme, TLS settings and root CA reference
+func validateUpstream(upstream models.Upstream) error {
+	if err := caddy.ValidateUpstream(upstream); err != nil {
+		return err
+	}
+	if upstream.TLSRootCAID != "" {
+		var cert models.CustomCertificate
+		if result := database.GetDB().First(&cert, "id = ?", upstream.TLSRootCAID); result.Error != nil {
+			return fmt.Errorf("root CA certificate not found")
+		}
+	}
+	return nil
+}
+
 // syncToCaddy rebuilds and applies configuration to Caddy
 func (h *UpstreamHandler) syncToCaddy() error {
 	// Build configuration from database
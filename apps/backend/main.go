@@ -58,6 +58,7 @@ func main() {
 
 	// Create Caddy client
 	caddyClient := caddy.NewClient(cfg.CaddyAPIURL)
+	caddy.SetSitesPath(cfg.SitesPath)
 
 	// Sync database state to Caddy on startup (restore after Caddy restart)
 	if err := syncDatabaseToCaddy(caddyClient); err != nil {
@@ -78,12 +79,16 @@ func main() {
 	upstreamHandler := handlers.NewUpstreamHandler(caddyClient)
 	configHandler := handlers.NewConfigHandler(caddyClient)
 	historyHandler := handlers.NewHistoryHandler(caddyClient)
-	tlsHandler := handlers.NewTLSHandler(caddyClient)
+	tlsHandler := handlers.NewTLSHandler(caddyClient, cfg.OnDemandAskAllowed)
 	certificateHandler := handlers.NewCertificateHandler("./storage/certificates")
-	middlewareHandler := handlers.NewMiddlewareHandler()
+	middlewareHandler := handlers.NewMiddlewareHandler(caddyClient)
+	maintenanceHandler := handlers.NewMaintenanceHandler(caddyClient)
 	authHandler := handlers.NewAuthHandler()
 	fileHandler := handlers.NewFileHandler(cfg.SitesPath)
 
+	// Toggle maintenance mode as scheduled windows start and end
+	maintenanceHandler.StartScheduler()
+
 	// Create sites directory if it doesn't exist
 	if err := os.MkdirAll(cfg.SitesPath, 0755); err != nil {
 		log.Printf("Warning: Failed to create sites directory: %v", err)
@@ -150,6 +155,7 @@ func main() {
 		// Site endpoints
 		api.GET("/sites", siteHandler.ListSites)
 		api.POST("/sites", siteHandler.CreateSite)
+		api.GET("/sites/conflicts", siteHandler.ListHostConflicts)
 		api.GET("/sites/:id", siteHandler.GetSite)
 		api.PUT("/sites/:id", siteHandler.UpdateSite)
 		api.DELETE("/sites/:id", siteHandler.DeleteSite)
@@ -164,10 +170,18 @@ func main() {
 		// TLS endpoints (nested under sites)
 		api.GET("/sites/:id/tls", tlsHandler.GetTLSConfig)
 		api.PUT("/sites/:id/tls", tlsHandler.UpdateTLSConfig)
+		api.POST("/tls/acme/check", tlsHandler.CheckACMEDirectory)
+		api.GET("/tls/ask", tlsHandler.AskOnDemand) // called by Caddy, restricted to loopback
+
+		// Server settings (timeouts, trusted proxies, protocols), nested under sites
+		api.GET("/sites/:id/server", siteHandler.GetServerSettings)
+		api.PUT("/sites/:id/server", siteHandler.UpdateServerSettings)
 
 		// Middleware endpoints (nested under sites)
 		api.GET("/sites/:id/middleware", middlewareHandler.GetMiddlewareSettings)
 		api.PUT("/sites/:id/middleware", middlewareHandler.UpdateMiddlewareSettings)
+		api.GET("/sites/:id/security/hsts-preload", middlewareHandler.GetHSTSPreloadCheck)
+		api.POST("/sites/:id/forward-auth/check", middlewareHandler.CheckForwardAuth)
 		
 		// Basic auth users (for sites)
 		api.GET("/sites/:id/auth/users", middlewareHandler.ListBasicAuthUsers)
@@ -194,6 +208,18 @@ func main() {
 		api.POST("/sites/:id/redirects", middlewareHandler.CreateRedirectRule)
 		api.DELETE("/redirects/:id", middlewareHandler.DeleteRedirectRule)
 
+		// Error pages
+		api.GET("/sites/:id/error-pages", middlewareHandler.ListErrorPages)
+		api.POST("/sites/:id/error-pages", middlewareHandler.CreateErrorPage)
+		api.DELETE("/error-pages/:id", middlewareHandler.DeleteErrorPage)
+
+		// Maintenance mode and scheduled windows
+		api.GET("/sites/:id/maintenance", maintenanceHandler.GetMaintenance)
+		api.PUT("/sites/:id/maintenance", maintenanceHandler.UpdateMaintenance)
+		api.GET("/sites/:id/maintenance/windows", maintenanceHandler.ListMaintenanceWindows)
+		api.POST("/sites/:id/maintenance/windows", maintenanceHandler.CreateMaintenanceWindow)
+		api.DELETE("/maintenance-windows/:id", maintenanceHandler.DeleteMaintenanceWindow)
+
 		// Upstream endpoints
 		api.GET("/upstreams", upstreamHandler.ListUpstreams)
 		api.POST("/upstreams", upstreamHandler.CreateUpstream)
@@ -319,6 +345,9 @@ func syncDatabaseToCaddy(client *caddy.Client) error {
 		return fmt.Errorf("failed to apply config: %w", err)
 	}
 
+	for _, warning := range config.Warnings {
+		log.Printf("Config build skipped an item: %s", warning)
+	}
 	log.Println("Synced configuration to Caddy")
 	return nil
 }
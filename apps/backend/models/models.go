@@ -1,6 +1,7 @@
 package models
 
 import (
+	"encoding/json"
 	"time"
 
 	"github.com/google/uuid"
@@ -17,6 +18,13 @@ type Site struct {
 	AutoHTTPS   bool      `json:"auto_https"`
 	TLSEnabled  bool      `json:"tls_enabled"`
 	Enabled     bool      `json:"enabled"`
+	// Maintenance mode: every request gets a 503 page except from bypass IPs or holders of the bypass cookie
+	MaintenanceEnabled       bool     `gorm:"default:false" json:"maintenance_enabled"`
+	MaintenanceRetryAfter    int      `gorm:"default:300" json:"maintenance_retry_after"`       // seconds, sent as Retry-After outside scheduled windows
+	MaintenancePage          string   `gorm:"type:text" json:"maintenance_page"`                // HTML body, a built-in page when empty
+	MaintenanceBypassIPs     []string `gorm:"-" json:"maintenance_bypass_ips"`                  // IPs or CIDRs served normally
+	MaintenanceBypassIPsJSON string   `gorm:"column:maintenance_bypass_ips;type:text" json:"-"` // Stored as JSON string
+	MaintenanceBypassSecret  string   `json:"maintenance_bypass_secret"`                        // value of the caddyadmin_maintenance_bypass cookie
 	CreatedAt   time.Time `json:"created_at"`
 	UpdatedAt   time.Time `json:"updated_at"`
 	Routes      []Route   `gorm:"foreignKey:SiteID;constraint:OnDelete:CASCADE" json:"routes,omitempty"`
@@ -38,8 +46,12 @@ type Route struct {
 	MatchType   string    `gorm:"default:path" json:"match_type"` // path, path_prefix, path_regexp
 	Methods     []string  `gorm:"-" json:"methods"`
 	MethodsJSON string    `gorm:"column:methods" json:"-"`
+	Matchers     *RouteMatchers `gorm:"-" json:"matchers,omitempty"`
+	MatchersJSON string         `gorm:"column:matchers;type:text" json:"-"` // Stored as JSON string
 	HandlerType string    `gorm:"not null" json:"handler_type"` // reverse_proxy, file_server, static_response, redirect, php_fastcgi
 	HandlerConfig string  `gorm:"type:text" json:"handler_config"` // JSON config for the handler
+	Handlers     []HandlerStep `gorm:"-" json:"handlers"`                  // Ordered handler pipeline; HandlerType/HandlerConfig mirror the last step
+	HandlersJSON string        `gorm:"column:handlers;type:text" json:"-"` // Stored as JSON string
 	Order       int       `gorm:"default:0" json:"order"`
 	Enabled     bool      `gorm:"default:true" json:"enabled"`
 	CreatedAt   time.Time `json:"created_at"`
@@ -53,12 +65,76 @@ func (r *Route) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// HandlerStep is one handler in a route's pipeline, e.g. rewrite -> headers -> reverse_proxy
+type HandlerStep struct {
+	Type   string                 `json:"type"`
+	Config map[string]interface{} `json:"config,omitempty"`
+}
+
+// HandlerSteps returns the route's handler pipeline, falling back to the legacy
+// single HandlerType/HandlerConfig pair for routes that predate pipelines
+func (r *Route) HandlerSteps() ([]HandlerStep, error) {
+	if r.HandlersJSON != "" {
+		var steps []HandlerStep
+		if err := json.Unmarshal([]byte(r.HandlersJSON), &steps); err != nil {
+			return nil, err
+		}
+		if len(steps) > 0 {
+			return steps, nil
+		}
+	}
+
+	step := HandlerStep{Type: r.HandlerType}
+	if r.HandlerConfig != "" {
+		if err := json.Unmarshal([]byte(r.HandlerConfig), &step.Config); err != nil {
+			return nil, err
+		}
+	}
+	return []HandlerStep{step}, nil
+}
+
+// SetHandlerSteps stores the pipeline and mirrors its last step into HandlerType/HandlerConfig
+func (r *Route) SetHandlerSteps(steps []HandlerStep) {
+	stepsJSON, _ := json.Marshal(steps)
+	r.HandlersJSON = string(stepsJSON)
+	r.Handlers = steps
+
+	if len(steps) == 0 {
+		return
+	}
+	last := steps[len(steps)-1]
+	r.HandlerType = last.Type
+	r.HandlerConfig = ""
+	if len(last.Config) > 0 {
+		configJSON, _ := json.Marshal(last.Config)
+		r.HandlerConfig = string(configJSON)
+	}
+}
+
+// RouteMatchers holds the optional request matchers of a route, combined with AND
+type RouteMatchers struct {
+	Header       map[string][]string `json:"header,omitempty"`        // e.g. {"X-Canary": ["1"]}
+	HeaderRegexp map[string]string   `json:"header_regexp,omitempty"` // header name -> pattern
+	Query        map[string][]string `json:"query,omitempty"`         // e.g. {"debug": ["true"]}
+	RemoteIP     []string            `json:"remote_ip,omitempty"`     // IPs/CIDRs of the direct peer
+	ClientIP     []string            `json:"client_ip,omitempty"`     // IPs/CIDRs of the client (honors trusted proxies)
+	Protocol     string              `json:"protocol,omitempty"`      // http, https, grpc, http/1.1, http/2, http/3
+	Expression   string              `json:"expression,omitempty"`    // CEL expression
+	Not          *RouteMatchers      `json:"not,omitempty"`           // Negated matcher set
+}
+
 // Upstream represents a backend server for reverse proxy
 type Upstream struct {
 	ID              string    `gorm:"primaryKey;type:varchar(36)" json:"id"`
 	Name            string    `gorm:"not null" json:"name"`
 	Address         string    `gorm:"not null" json:"address"` // e.g., "localhost:8080"
 	Scheme          string    `gorm:"default:http" json:"scheme"` // http, https, h2c
+	// Backend TLS settings, used when Scheme is https
+	TLSServerName         string `json:"tls_server_name"`
+	TLSInsecureSkipVerify bool   `gorm:"default:false" json:"tls_insecure_skip_verify"`
+	TLSRootCAID           string `gorm:"type:varchar(36)" json:"tls_root_ca_id"`        // CustomCertificate trusted as root CA
+	TLSClientCertPath     string `json:"tls_client_cert_path"`                          // client certificate file on the Caddy host (mTLS)
+	TLSClientKeyPath      string `json:"tls_client_key_path"`
 	Weight          int       `gorm:"default:1" json:"weight"`
 	MaxRequests     int       `gorm:"default:0" json:"max_requests"` // 0 = unlimited
 	MaxConnections  int       `gorm:"default:0" json:"max_connections"`
@@ -81,12 +157,29 @@ func (u *Upstream) BeforeCreate(tx *gorm.DB) error {
 type UpstreamGroup struct {
 	ID              string     `gorm:"primaryKey;type:varchar(36)" json:"id"`
 	Name            string     `gorm:"not null;unique" json:"name"`
-	LoadBalancing   string     `gorm:"default:round_robin" json:"load_balancing"` // round_robin, least_conn, first, random, ip_hash, uri_hash, header, cookie
+	LoadBalancing   string     `gorm:"default:round_robin" json:"load_balancing"` // round_robin, weighted_round_robin, least_conn, first, random, ip_hash, uri_hash, header, cookie, query
+	// Selection policy options
+	LoadBalancingHeader       string `json:"load_balancing_header"`        // header policy: field to hash
+	LoadBalancingCookie       string `json:"load_balancing_cookie"`        // cookie policy: cookie name (Caddy defaults to "lb")
+	LoadBalancingCookieSecret string `json:"load_balancing_cookie_secret"` // cookie policy: HMAC secret
+	LoadBalancingQueryKey     string `json:"load_balancing_query_key"`     // query policy: parameter to hash
 	TryDuration     int        `gorm:"default:0" json:"try_duration"` // seconds
 	TryInterval     int        `gorm:"default:250" json:"try_interval"` // milliseconds
 	HealthChecks    bool       `gorm:"default:false" json:"health_checks"`
 	PassiveHealth   bool       `gorm:"default:true" json:"passive_health"`
 	Retries         int        `gorm:"default:3" json:"retries"`
+	// Active health checks; HealthCheckPath overrides the upstreams' own paths
+	HealthCheckPath         string            `json:"health_check_path"`
+	HealthCheckTimeout      int               `gorm:"default:5" json:"health_check_timeout"`      // seconds
+	HealthCheckExpectStatus int               `gorm:"default:0" json:"health_check_expect_status"` // 0 = any 2xx
+	HealthCheckHeaders      map[string]string `gorm:"-" json:"health_check_headers"`
+	HealthCheckHeadersJSON  string            `gorm:"column:health_check_headers;type:text" json:"-"`
+	// Passive health checks
+	PassiveFailDuration        int    `gorm:"default:30" json:"passive_fail_duration"` // seconds
+	PassiveMaxFails            int    `gorm:"default:1" json:"passive_max_fails"`
+	PassiveUnhealthyStatus     []int  `gorm:"-" json:"passive_unhealthy_status"`
+	PassiveUnhealthyStatusJSON string `gorm:"column:passive_unhealthy_status;type:text" json:"-"`
+	PassiveUnhealthyLatency    int    `gorm:"default:0" json:"passive_unhealthy_latency"` // milliseconds, 0 = disabled
 	CreatedAt       time.Time  `json:"created_at"`
 	UpdatedAt       time.Time  `json:"updated_at"`
 	Upstreams       []Upstream `gorm:"many2many:upstream_group_members" json:"upstreams,omitempty"`
@@ -105,14 +198,25 @@ type TLSConfig struct {
 	SiteID             string    `gorm:"uniqueIndex" json:"site_id"`
 	AutoHTTPS          bool      `gorm:"default:true" json:"auto_https"`
 	ACMEEmail          string    `json:"acme_email"`
-	ACMEProvider       string    `gorm:"default:letsencrypt" json:"acme_provider"` // letsencrypt, zerossl, custom
+	ACMEProvider       string    `gorm:"default:letsencrypt" json:"acme_provider"` // letsencrypt, zerossl, buypass, custom
+	// ACME issuer overrides; empty values fall back to the global defaults
+	ACMEDirectory       string `json:"acme_directory"`         // custom directory URL, e.g. step-ca or Pebble
+	ACMEStaging         bool   `gorm:"default:false" json:"acme_staging"`
+	ACMEEABKeyID        string `json:"acme_eab_key_id"`        // External Account Binding
+	ACMEEABMACKey       string `json:"acme_eab_mac_key"`
+	ACMEPreferredChains string `gorm:"type:text" json:"acme_preferred_chains"` // root common names, JSON array or comma-separated
+	ACMETrustedRoots    string `json:"acme_trusted_roots"`     // PEM file on the Caddy host trusted for the directory
+	KeyType             string `json:"key_type"`               // ed25519, p256, p384, rsa2048, rsa4096
 	OnDemandTLS        bool      `gorm:"default:false" json:"on_demand_tls"`
 	WildcardCert       bool      `gorm:"default:false" json:"wildcard_cert"`
 	DNSProviderID      string    `json:"dns_provider_id"`
-	CustomCertPath     string    `json:"custom_cert_path"`
+	CertificateID      string    `gorm:"type:varchar(36)" json:"certificate_id"` // uploaded CustomCertificate served for the site's hosts
+	CustomCertPath     string    `json:"custom_cert_path"`                        // certificate file on the Caddy host
 	CustomKeyPath      string    `json:"custom_key_path"`
-	MinVersion         string    `gorm:"default:tls1.2" json:"min_version"` // tls1.0, tls1.1, tls1.2, tls1.3
-	CipherSuites       string    `gorm:"type:text" json:"cipher_suites"` // JSON array
+	MinVersion         string    `gorm:"default:tls1.2" json:"min_version"` // tls1.2, tls1.3
+	CipherSuites       string    `gorm:"type:text" json:"cipher_suites"` // JSON array or comma-separated
+	Curves             string    `gorm:"type:text" json:"curves"`        // JSON array or comma-separated, e.g. x25519,secp256r1
+	ALPN               string    `gorm:"type:text" json:"alpn"`          // JSON array or comma-separated, e.g. h2,http/1.1
 	CreatedAt          time.Time `json:"created_at"`
 	UpdatedAt          time.Time `json:"updated_at"`
 }
@@ -124,6 +228,33 @@ func (t *TLSConfig) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// ServerSettings overrides the global server defaults for the server a site listens on.
+// Empty values inherit; sites sharing a port share one server, so the first site wins.
+type ServerSettings struct {
+	ID                 string    `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	SiteID             string    `gorm:"uniqueIndex" json:"site_id"`
+	ReadTimeout        string    `json:"read_timeout"` // e.g. 30s, 1m
+	ReadHeaderTimeout  string    `json:"read_header_timeout"`
+	WriteTimeout       string    `json:"write_timeout"`
+	IdleTimeout        string    `json:"idle_timeout"`
+	MaxHeaderBytes     int       `gorm:"default:0" json:"max_header_bytes"`
+	TrustedProxies     string    `gorm:"type:text" json:"trusted_proxies"`      // added to the global list; IPs, CIDRs, private_ranges or cloudflare
+	ClientIPHeaders    string    `gorm:"type:text" json:"client_ip_headers"`    // JSON array or comma-separated, e.g. CF-Connecting-IP
+	Protocols          string    `gorm:"type:text" json:"protocols"`            // JSON array or comma-separated: h1, h2, h2c, h3
+	ProxyProtocol      *bool     `json:"proxy_protocol"`                        // null inherits the global setting
+	ProxyProtocolAllow string    `gorm:"type:text" json:"proxy_protocol_allow"` // load balancer IPs or CIDRs
+	StrictSNIHost      *bool     `json:"strict_sni_host"`                       // null inherits the global setting
+	CreatedAt          time.Time `json:"created_at"`
+	UpdatedAt          time.Time `json:"updated_at"`
+}
+
+func (ss *ServerSettings) BeforeCreate(tx *gorm.DB) error {
+	if ss.ID == "" {
+		ss.ID = uuid.New().String()
+	}
+	return nil
+}
+
 // ConfigHistory stores configuration change history for rollback
 type ConfigHistory struct {
 	ID            string    `gorm:"primaryKey;type:varchar(36)" json:"id"`
@@ -139,6 +270,7 @@ type ConfigHistory struct {
 	IPAddress     string    `json:"ip_address"`
 	Success       bool      `gorm:"default:true" json:"success"`
 	ErrorMessage  string    `json:"error_message,omitempty"`
+	Warnings      string    `gorm:"type:text" json:"warnings,omitempty"` // Items the config build skipped, one per line
 }
 
 func (ch *ConfigHistory) BeforeCreate(tx *gorm.DB) error {
@@ -164,13 +296,36 @@ type GlobalSettings struct {
 	ErrorLogEnabled   bool      `gorm:"default:true" json:"error_log_enabled"`
 	// Global TLS Settings
 	DefaultACMEEmail    string `json:"default_acme_email"`
-	DefaultACMEProvider string `gorm:"default:letsencrypt" json:"default_acme_provider"` // letsencrypt, zerossl, buypass
-	DefaultMinTLS       string `gorm:"default:tls1.2" json:"default_min_tls"`            // tls1.0, tls1.1, tls1.2, tls1.3
+	DefaultACMEProvider string `gorm:"default:letsencrypt" json:"default_acme_provider"` // letsencrypt, zerossl, buypass, custom
+	DefaultACMEDirectory       string `json:"default_acme_directory"`
+	DefaultACMEStaging         bool   `gorm:"default:false" json:"default_acme_staging"`
+	DefaultACMEEABKeyID        string `json:"default_acme_eab_key_id"`
+	DefaultACMEEABMACKey       string `json:"default_acme_eab_mac_key"`
+	DefaultACMEPreferredChains string `gorm:"type:text" json:"default_acme_preferred_chains"`
+	DefaultACMETrustedRoots    string `json:"default_acme_trusted_roots"`
+	DefaultKeyType             string `json:"default_key_type"`
+	DefaultMinTLS       string `gorm:"default:tls1.2" json:"default_min_tls"`            // tls1.2, tls1.3
+	DefaultCipherSuites string `gorm:"type:text" json:"default_cipher_suites"`            // JSON array or comma-separated
+	DefaultCurves       string `gorm:"type:text" json:"default_curves"`
+	DefaultALPN         string `gorm:"type:text" json:"default_alpn"`
 	OnDemandTLSEnabled  bool   `gorm:"default:false" json:"on_demand_tls_enabled"`
+	OnDemandAskURL      string `gorm:"default:http://localhost:4000/api/tls/ask" json:"on_demand_ask_url"` // permission endpoint Caddy calls before issuing
 	HSTSEnabled         bool   `gorm:"default:false" json:"hsts_enabled"`
 	HSTSMaxAge          int    `gorm:"default:31536000" json:"hsts_max_age"` // 1 year default
 	HSTSIncludeSubs     bool   `gorm:"default:true" json:"hsts_include_subs"`
 	HSTSPreload         bool   `gorm:"default:false" json:"hsts_preload"`
+	// Server defaults, overridden per site by ServerSettings
+	ServerReadTimeout        string `json:"server_read_timeout"` // e.g. 30s; empty leaves Caddy's default
+	ServerReadHeaderTimeout  string `json:"server_read_header_timeout"`
+	ServerWriteTimeout       string `json:"server_write_timeout"`
+	ServerIdleTimeout        string `json:"server_idle_timeout"`
+	ServerMaxHeaderBytes     int    `gorm:"default:0" json:"server_max_header_bytes"`
+	ServerTrustedProxies     string `gorm:"type:text" json:"server_trusted_proxies"`      // IPs, CIDRs, private_ranges or cloudflare; JSON array or comma-separated
+	ServerClientIPHeaders    string `gorm:"type:text" json:"server_client_ip_headers"`    // e.g. CF-Connecting-IP; JSON array or comma-separated
+	ServerProtocols          string `gorm:"type:text" json:"server_protocols"`            // h1, h2, h2c, h3; JSON array or comma-separated
+	ServerProxyProtocol      bool   `gorm:"default:false" json:"server_proxy_protocol"`   // accept PROXY protocol from a load balancer
+	ServerProxyProtocolAllow string `gorm:"type:text" json:"server_proxy_protocol_allow"` // load balancer IPs or CIDRs
+	ServerStrictSNIHost      bool   `gorm:"default:false" json:"server_strict_sni_host"`
 	CreatedAt           time.Time `json:"created_at"`
 	UpdatedAt           time.Time `json:"updated_at"`
 }
@@ -283,17 +438,96 @@ func (rr *RedirectRule) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+// ErrorPage replaces Caddy's bare error response for matching status codes on a site
+type ErrorPage struct {
+	ID           string    `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	SiteID       string    `gorm:"index;not null" json:"site_id"`
+	StatusCodes  string    `gorm:"not null" json:"status_codes"`     // e.g. "404", "502,503,504", "5xx", "400-499", "*"
+	Action       string    `gorm:"default:file" json:"action"`       // file, static, redirect
+	FilePath     string    `json:"file_path"`                        // file: HTML file relative to the site's public directory
+	Body         string    `gorm:"type:text" json:"body"`            // static: body, may use {http.error.status_code} and friends
+	ContentType  string    `json:"content_type"`                     // static: defaults to text/html; charset=utf-8
+	Location     string    `json:"location"`                         // redirect: target URL
+	RedirectCode int       `gorm:"default:302" json:"redirect_code"` // redirect: 301, 302, 307, 308
+	Priority     int       `gorm:"default:0" json:"priority"`
+	Enabled      bool      `gorm:"default:true" json:"enabled"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+}
+
+func (ep *ErrorPage) BeforeCreate(tx *gorm.DB) error {
+	if ep.ID == "" {
+		ep.ID = uuid.New().String()
+	}
+	return nil
+}
+
+// MaintenanceWindow schedules a period during which a site is put into maintenance mode
+type MaintenanceWindow struct {
+	ID        string    `gorm:"primaryKey;type:varchar(36)" json:"id"`
+	SiteID    string    `gorm:"index;not null" json:"site_id"`
+	StartsAt  time.Time `gorm:"index;not null" json:"starts_at"`
+	EndsAt    time.Time `gorm:"not null" json:"ends_at"`
+	Reason    string    `json:"reason"`
+	Status    string    `gorm:"default:scheduled;index" json:"status"` // scheduled, active, completed
+	// Set when the window switched maintenance on, or took over from one that did; only then
+	// does ending it switch maintenance off, leaving manually enabled maintenance alone
+	HoldsMaintenance bool      `gorm:"default:false" json:"holds_maintenance"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+func (mw *MaintenanceWindow) BeforeCreate(tx *gorm.DB) error {
+	if mw.ID == "" {
+		mw.ID = uuid.New().String()
+	}
+	return nil
+}
+
 // MiddlewareSettings represents per-site middleware configuration
 type MiddlewareSettings struct {
 	ID                string `gorm:"primaryKey;type:varchar(36)" json:"id"`
 	SiteID            string `gorm:"uniqueIndex;not null" json:"site_id"`
 	CompressionEnabled bool  `gorm:"default:false" json:"compression_enabled"`
-	CompressionTypes  string `gorm:"default:gzip" json:"compression_types"` // gzip, zstd, br
+	CompressionTypes  string `gorm:"default:gzip" json:"compression_types"` // comma-separated in preference order: gzip, zstd
 	CompressionLevel  int    `gorm:"default:5" json:"compression_level"`    // 1-9
+	CompressionMinLength int `gorm:"default:512" json:"compression_min_length"` // bytes
 	BasicAuthEnabled  bool   `gorm:"default:false" json:"basic_auth_enabled"`
 	BasicAuthRealm    string `gorm:"default:Restricted" json:"basic_auth_realm"`
+	BasicAuthExclude     []string `gorm:"-" json:"basic_auth_exclude"`                  // Paths served without auth, e.g. "/health"
+	BasicAuthExcludeJSON string   `gorm:"column:basic_auth_exclude;type:text" json:"-"` // Stored as JSON string
+	// Forward auth: an identity proxy (Authelia, Authentik, oauth2-proxy) authorizes each request
+	ForwardAuthEnabled         bool     `gorm:"default:false" json:"forward_auth_enabled"`
+	ForwardAuthProvider        string   `gorm:"default:custom" json:"forward_auth_provider"`         // authelia, authentik, oauth2-proxy, custom
+	ForwardAuthUpstream        string   `json:"forward_auth_upstream"`                               // e.g. "authelia:9091" or "https://auth.internal"
+	ForwardAuthURI             string   `json:"forward_auth_uri"`                                    // verification endpoint, defaults per provider
+	ForwardAuthCopyHeaders     []string `gorm:"-" json:"forward_auth_copy_headers"`                  // defaults per provider, e.g. Remote-User, Remote-Groups
+	ForwardAuthCopyHeadersJSON string   `gorm:"column:forward_auth_copy_headers;type:text" json:"-"` // Stored as JSON string
+	ForwardAuthExclude         []string `gorm:"-" json:"forward_auth_exclude"`                       // Paths served without auth
+	ForwardAuthExcludeJSON     string   `gorm:"column:forward_auth_exclude;type:text" json:"-"`      // Stored as JSON string
+	// CORS: allow-listed origins are reflected back, preflights answered with 204
+	CORSEnabled            bool     `gorm:"default:false" json:"cors_enabled"`
+	CORSAllowedOrigins     []string `gorm:"-" json:"cors_allowed_origins"`                  // exact, wildcard ("https://*.example.com") or "*"
+	CORSAllowedOriginsJSON string   `gorm:"column:cors_allowed_origins;type:text" json:"-"` // Stored as JSON string
+	CORSAllowedMethods     []string `gorm:"-" json:"cors_allowed_methods"`                  // defaults to GET, POST, PUT, PATCH, DELETE
+	CORSAllowedMethodsJSON string   `gorm:"column:cors_allowed_methods;type:text" json:"-"` // Stored as JSON string
+	CORSAllowedHeaders     []string `gorm:"-" json:"cors_allowed_headers"`                  // defaults to Content-Type, Authorization
+	CORSAllowedHeadersJSON string   `gorm:"column:cors_allowed_headers;type:text" json:"-"` // Stored as JSON string
+	CORSExposedHeaders     []string `gorm:"-" json:"cors_exposed_headers"`
+	CORSExposedHeadersJSON string   `gorm:"column:cors_exposed_headers;type:text" json:"-"` // Stored as JSON string
+	CORSAllowCredentials   bool     `gorm:"default:false" json:"cors_allow_credentials"`
+	CORSMaxAge             int      `gorm:"default:0" json:"cors_max_age"`                  // seconds
 	AccessControlEnabled bool `gorm:"default:false" json:"access_control_enabled"`
 	AccessControlDefault string `gorm:"default:allow" json:"access_control_default"` // allow, deny
+	// Security headers: a preset plus per-header overrides ("off" removes a preset header)
+	SecurityPreset       string              `gorm:"default:none" json:"security_preset"` // none, basic, strict
+	XContentTypeOptions  string              `json:"x_content_type_options"`
+	XFrameOptions        string              `json:"x_frame_options"`
+	ReferrerPolicy       string              `json:"referrer_policy"`
+	PermissionsPolicy    string              `json:"permissions_policy"`
+	CSPDirectives        map[string][]string `gorm:"-" json:"csp_directives"`                 // merged over the preset, per directive
+	CSPDirectivesJSON    string              `gorm:"column:csp_directives;type:text" json:"-"` // Stored as JSON string
+	CSPReportOnly        bool                `gorm:"default:false" json:"csp_report_only"`
 	CreatedAt         time.Time `json:"created_at"`
 	UpdatedAt         time.Time `json:"updated_at"`
 }
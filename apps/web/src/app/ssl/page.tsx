@@ -196,8 +196,6 @@ export default function SSLPage() {
                                         <SelectValue />
                                     </SelectTrigger>
                                     <SelectContent>
-                                        <SelectItem value="tls1.0">TLS 1.0 (Legacy)</SelectItem>
-                                        <SelectItem value="tls1.1">TLS 1.1 (Legacy)</SelectItem>
                                         <SelectItem value="tls1.2">TLS 1.2 (Standard)</SelectItem>
                                         <SelectItem value="tls1.3">TLS 1.3 (Modern Only)</SelectItem>
                                     </SelectContent>
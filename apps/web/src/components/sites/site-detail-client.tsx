@@ -723,8 +723,6 @@ export default function SiteDetailClient() {
                                                     <SelectValue />
                                                 </SelectTrigger>
                                                 <SelectContent>
-                                                    <SelectItem value="tls1.0">TLS 1.0 (Not Recommended)</SelectItem>
-                                                    <SelectItem value="tls1.1">TLS 1.1 (Legacy)</SelectItem>
                                                     <SelectItem value="tls1.2">TLS 1.2 (Recommended)</SelectItem>
                                                     <SelectItem value="tls1.3">TLS 1.3 (Modern)</SelectItem>
                                                 </SelectContent>
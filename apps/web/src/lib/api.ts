@@ -69,6 +69,7 @@ export interface ConfigHistory {
     new_state: string;
     success: boolean;
     error_message?: string;
+    warnings?: string;
 }
 
 export interface HealthStatus {
@@ -183,7 +184,7 @@ export const createUpstreamGroup = (data: Partial<UpstreamGroup>) =>
 // Config
 export const getConfig = () => fetchAPI<Record<string, unknown>>('/api/config');
 export const syncConfig = () =>
-    fetchAPI<{ message: string; config: Record<string, unknown> }>('/api/config/sync', { method: 'POST' });
+    fetchAPI<{ message: string; config: Record<string, unknown>; warnings?: string[] }>('/api/config/sync', { method: 'POST' });
 
 // History
 export const getHistory = (params?: { limit?: number; resource_type?: string }) => {